@@ -16,6 +16,8 @@ limitations under the License.
 package cmd
 
 import (
+	"time"
+
 	awsSDK "github.com/aws/aws-sdk-go/aws"
 	aws "github.com/doitintl/secrets-consumer-env/pkg/aws"
 	"github.com/spf13/cobra"
@@ -27,6 +29,15 @@ var (
 	secretNameAWS   string
 	previousVersion string
 	roleARN         string
+	endpointURL     string
+	stsEndpoint     string
+	useFIPS         bool
+	useDualStack    bool
+	namePrefix      string
+	awsTags         []string
+	awsNamesAsKeys  bool
+	awsMaxRetries   int
+	awsTimeout      time.Duration
 )
 
 // awsCmd represents the aws command
@@ -35,18 +46,41 @@ var awsCmd = &cobra.Command{
 	Short: "Secrets Consumer for AWS Secret Manager",
 	Long: `AWS secret manager can hold secrets in a json format. the secret can be rotated using a lambda function
 and the only versions that AWS secret manager knows are CURRENT_VERSION and PREVIOUS_VERSION
-you have the option of specifying PREVIOUS_VERSION=true to fetch previous version`,
+you have the option of specifying PREVIOUS_VERSION=true to fetch previous version
+
+The Secrets Manager endpoint can be overridden with --endpoint-url (e.g. LocalStack or a VPC interface endpoint
+private DNS name), and the STS endpoint used to assume --role-arn with --sts-endpoint
+
+Instead of a single --secret-name you can discover secrets with --name-prefix and/or --tag key=value,
+every matching secret is fetched and injected, like a Vault path ending with a "/" or a wildcard.
+With --names-as-keys the last segment of each secret name is used as the key, for example
+prod/payments/DB_PASSWORD is exported as DB_PASSWORD`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var (
 			secretData map[string]interface{}
 			err        error
 		)
 
+		tags, err := aws.ParseTags(awsTags)
+		if err != nil {
+			exitWithError("Error parsing AWS secret tag filters", err)
+		}
+
 		cfg := &aws.Config{
-			Region:          region,
-			RoleARN:         roleARN,
-			PreviousVersion: previousVersion,
-			SecretName:      awsSDK.String(secretNameAWS),
+			Region:               region,
+			RoleARN:              roleARN,
+			PreviousVersion:      previousVersion,
+			SecretName:           awsSDK.String(secretNameAWS),
+			EndpointURL:          endpointURL,
+			STSEndpoint:          stsEndpoint,
+			UseFIPSEndpoint:      useFIPS,
+			UseDualStackEndpoint: useDualStack,
+			NamePrefix:           namePrefix,
+			Tags:                 tags,
+			UseSecretNamesAsKeys: awsNamesAsKeys,
+			MaxRetries:           awsMaxRetries,
+			Timeout:              awsTimeout,
+			Recorder:             recorder,
 		}
 
 		secretData, err = aws.RetrieveSecret(cfg)
@@ -64,10 +98,31 @@ func init() {
 	viper.SetDefault("role_arn", "")
 	viper.SetDefault("secret_name", "")
 	viper.SetDefault("previous_version", "")
+	viper.SetDefault("aws_endpoint_url", "")
+	viper.SetDefault("aws_sts_endpoint", "")
+	viper.SetDefault("aws_use_fips_endpoint", false)
+	viper.SetDefault("aws_use_dualstack_endpoint", false)
+	viper.SetDefault("aws_secret_name_prefix", "")
+	viper.SetDefault("aws_secret_tags", []string{})
+	viper.SetDefault("aws_use_secret_names_as_keys", false)
+	viper.SetDefault("aws_max_retries", aws.DefaultMaxRetries)
+	viper.SetDefault("aws_timeout", aws.DefaultTimeout)
 	viper.AutomaticEnv()
 
 	awsCmd.Flags().StringVar(&region, "region", viper.GetString("region"), "AWS Region for the Secret Manager (default: us-east-1)")
 	awsCmd.Flags().StringVar(&roleARN, "role-arn", viper.GetString("role_arn"), "AWS Role ARN with access to the secret, this requires also permissions on the KMS key for that role")
 	awsCmd.Flags().StringVar(&secretNameAWS, "secret-name", viper.GetString("secret_name"), "AWS Secret Name")
 	awsCmd.Flags().StringVar(&previousVersion, "previous-version", viper.GetString("previous_version"), "If using lambda to rotate secrets you can get the previous version (default: current version)")
+	awsCmd.Flags().StringVar(&endpointURL, "endpoint-url", viper.GetString("aws_endpoint_url"), "Custom Secrets Manager endpoint URL, e.g. LocalStack or a VPC interface endpoint private DNS name")
+	awsCmd.Flags().StringVar(&stsEndpoint, "sts-endpoint", viper.GetString("aws_sts_endpoint"), "Custom STS endpoint URL used to assume the role ARN")
+	awsCmd.Flags().BoolVar(&useFIPS, "use-fips-endpoint", viper.GetBool("aws_use_fips_endpoint"), "Use the FIPS 140-2 Secrets Manager and STS endpoints (default false)")
+	awsCmd.Flags().BoolVar(&useDualStack, "use-dualstack-endpoint", viper.GetBool("aws_use_dualstack_endpoint"), "Use the dual-stack (IPv4 and IPv6) Secrets Manager and STS endpoints (default false)")
+
+	// Discover secrets by name prefix and/or tags
+	awsCmd.Flags().StringVar(&namePrefix, "name-prefix", viper.GetString("aws_secret_name_prefix"), "Fetch every secret whose name starts with this prefix, e.g. prod/payments/")
+	awsCmd.Flags().StringArrayVar(&awsTags, "tag", viper.GetStringSlice("aws_secret_tags"), "Fetch every secret with this tag as key=value, can be specified multiple times")
+	awsCmd.Flags().BoolVar(&awsNamesAsKeys, "names-as-keys", viper.GetBool("aws_use_secret_names_as_keys"), "Use the discovered secret names as keys and their secret string as value (default false)")
+
+	awsCmd.Flags().IntVar(&awsMaxRetries, "max-retries", viper.GetInt("aws_max_retries"), "Retries with exponential backoff for throttled and transient errors, a negative value disables retries")
+	awsCmd.Flags().DurationVar(&awsTimeout, "timeout", viper.GetDuration("aws_timeout"), "Overall timeout for retrieving the secrets")
 }
@@ -0,0 +1,156 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	azure "github.com/doitintl/secrets-consumer-env/pkg/azure"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	keyVaultName            string
+	keyVaultURL             string
+	secretNameAzure         string
+	secretVersionAzure      string
+	azureSecretConfigs      []string
+	azureCertificates       []string
+	azureAuth               string
+	azureTenantID           string
+	azureClientID           string
+	azureClientSecret       string
+	azureFederatedTokenFile string
+	azureAuthorityHost      string
+)
+
+// azureCmd represents the azure command
+var azureCmd = &cobra.Command{
+	Use:   "azure",
+	Short: "Secrets Consumer for Azure Key Vault",
+	Long: `Azure Key Vault holds secrets as plain text values, a secret passed with --secret-name is exported under its
+name normalized to an env var name, Key Vault names only allow alphanumerics and dashes so` + " `db-password` " + `is exported
+as` + " `DB_PASSWORD`" + `.
+
+Multiple secrets can be passed with --secret-config, JSON secrets can be expanded into keys with the json format:
+'{"name": "db-password", "env": "DATABASE_PASSWORD"}' '{"name": "app-config", "format": "json"}'
+
+Certificates are exported as their PEM public certificate with --certificate or '{"name": "tls", "kind": "certificate"}',
+the private key of a certificate is available as the secret with the same name.
+
+The vault is set with --vault-name (or --vault-url for the sovereign clouds), the authentication method with --auth:
+
+* ` + "`client-secret`" + ` - a service principal with AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET
+* ` + "`workload-identity`" + ` - AKS workload identity with AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_FEDERATED_TOKEN_FILE
+* ` + "`managed-identity`" + ` - the managed identity of the host, AZURE_CLIENT_ID selects a user assigned identity
+
+by default the method is picked from the variables that are set, in that order.
+
+The identity must have the` + " `Key Vault Secrets User` " + `role (or a get secrets access policy) on the vault,
+and` + " `Key Vault Certificate User` " + `for certificates`,
+	Args: validateAzureFlags,
+	Run: func(cmd *cobra.Command, args []string) {
+		var (
+			secretData map[string]interface{}
+			err        error
+		)
+		secretsConfigList, err := azure.ConfigureSecrets(azureSecretConfigs)
+		if err != nil {
+			exitWithError("error configuring Azure Key Vault secrets", err)
+		}
+		secretsConfigList = append(secretsConfigList, azure.CertificateConfigs(azureCertificates)...)
+
+		vaultURL := keyVaultURL
+		if vaultURL == "" {
+			vaultURL = azure.VaultURL(keyVaultName)
+		}
+		cfg := &azure.Config{
+			VaultURL:           vaultURL,
+			SecretName:         secretNameAzure,
+			SecretVersion:      secretVersionAzure,
+			SecretsConfigList:  secretsConfigList,
+			Auth:               azureAuth,
+			TenantID:           azureTenantID,
+			ClientID:           azureClientID,
+			ClientSecret:       azureClientSecret,
+			FederatedTokenFile: azureFederatedTokenFile,
+			AuthorityHost:      azureAuthorityHost,
+			Recorder:           recorder,
+		}
+		client, err := azure.NewClient(context.Background(), cfg)
+		if err != nil {
+			exitWithError("error creating new Azure Key Vault client", err)
+		}
+		log.Info("Using Azure Key Vault")
+		secretData, err = azure.RetrieveSecret(client, cfg)
+		if err != nil {
+			exitWithError("error retrieving secrets from Azure Key Vault", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func validateAzureFlags(cmd *cobra.Command, args []string) error {
+	if keyVaultName == "" && keyVaultURL == "" {
+		return errors.New("Key Vault name is missing, pass it via --vault-name flag or set AZURE_KEY_VAULT_NAME environment variable, you can also use --vault-url")
+	}
+	if secretNameAzure == "" && len(azureSecretConfigs) == 0 && len(azureCertificates) == 0 {
+		return errors.New("Secret Name is missing, pass it via --secret-name flag or set SECRET_NAME environment variable, you can also use --secret-config or --certificate flags")
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(azureCmd)
+
+	viper.SetDefault("azure_key_vault_name", "")
+	viper.SetDefault("azure_key_vault_url", "")
+	viper.SetDefault("secret_name", "")
+	viper.SetDefault("azure_secret_version", "")
+	viper.SetDefault("azure_auth", "")
+	viper.SetDefault("azure_tenant_id", "")
+	viper.SetDefault("azure_client_id", "")
+	viper.SetDefault("azure_client_secret", "")
+	viper.SetDefault("azure_federated_token_file", "")
+	viper.SetDefault("azure_authority_host", azure.DefaultAuthorityHost)
+	viper.AutomaticEnv()
+
+	azureCmd.Flags().StringVar(&keyVaultName, "vault-name", viper.GetString("azure_key_vault_name"), "Azure Key Vault name")
+	azureCmd.Flags().StringVar(&keyVaultURL, "vault-url", viper.GetString("azure_key_vault_url"), "Azure Key Vault URL, e.g. https://<vault-name>.vault.azure.cn (default: https://<vault-name>.vault.azure.net)")
+	azureCmd.Flags().StringVar(&secretNameAzure, "secret-name", viper.GetString("secret_name"), "Azure Key Vault secret name")
+	azureCmd.Flags().StringVar(&secretVersionAzure, "secret-version", viper.GetString("azure_secret_version"), "Azure Key Vault secret version (default: latest)")
+
+	// Multiple secrets via JSON string
+	azureCmd.Flags().StringArrayVarP(
+		&azureSecretConfigs,
+		"secret-config",
+		"",
+		[]string{},
+		"multiple secrets in JSON string like: '{\"name\": \"db-password\", \"version\": \"<version>\", \"format\": \"raw\", \"env\": \"DB_PASSWORD\"}' can be specified a multiple times",
+	)
+	azureCmd.Flags().StringArrayVar(&azureCertificates, "certificate", []string{}, "Azure Key Vault certificate name to export as PEM, can be specified multiple times")
+
+	// Credentials
+	azureCmd.Flags().StringVar(&azureAuth, "auth", viper.GetString("azure_auth"), "Authentication method [client-secret, workload-identity, managed-identity] (default: picked from the credentials variables)")
+	azureCmd.Flags().StringVar(&azureTenantID, "tenant-id", viper.GetString("azure_tenant_id"), "Azure AD tenant ID")
+	azureCmd.Flags().StringVar(&azureClientID, "client-id", viper.GetString("azure_client_id"), "Azure AD application or managed identity client ID")
+	azureCmd.Flags().StringVar(&azureClientSecret, "client-secret", viper.GetString("azure_client_secret"), "Azure AD application client secret")
+	azureCmd.Flags().StringVar(&azureFederatedTokenFile, "federated-token-file", viper.GetString("azure_federated_token_file"), "File path of the federated token for workload identity")
+	azureCmd.Flags().StringVar(&azureAuthorityHost, "authority-host", viper.GetString("azure_authority_host"), "Azure AD authority host")
+}
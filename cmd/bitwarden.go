@@ -0,0 +1,81 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/bitwarden"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	bitwardenHost  string
+	bitwardenItems []string
+	bitwardenSync  bool
+)
+
+// bitwardenCmd represents the bitwarden command
+var bitwardenCmd = &cobra.Command{
+	Use:     "bitwarden",
+	Aliases: []string{"vaultwarden"},
+	Short:   "Secrets Consumer for Bitwarden and Vaultwarden",
+	Long: `Bitwarden items are read with the Vault Management API of the Bitwarden CLI, run` + " `bw serve` " + `next to
+the application, logged in to Bitwarden or a Vaultwarden server and unlocked. An --item is an item name or ID,
+later items override earlier ones.
+
+The login of an item is exported as USERNAME and PASSWORD and every custom field under its name,
+for example a "db password" field is exported as DB_PASSWORD`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(bitwardenItems) == 0 {
+			return errors.New("Bitwarden item is missing, pass it via --item flag or set BW_ITEMS environment variable")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := &bitwarden.Config{
+			Host:     bitwardenHost,
+			Items:    bitwardenItems,
+			Sync:     bitwardenSync,
+			Recorder: recorder,
+		}
+		client, err := bitwarden.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating new Bitwarden client", err)
+		}
+		log.Info("Using Bitwarden")
+		secretData, err := bitwarden.RetrieveSecret(client, cfg)
+		if err != nil {
+			exitWithError("error retrieving secrets from Bitwarden", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(bitwardenCmd)
+
+	viper.SetDefault("bw_serve_host", bitwarden.DefaultHost)
+	viper.SetDefault("bw_items", []string{})
+	viper.SetDefault("bw_sync", false)
+	viper.AutomaticEnv()
+
+	bitwardenCmd.Flags().StringVar(&bitwardenHost, "host", viper.GetString("bw_serve_host"), "URL of bw serve")
+	bitwardenCmd.Flags().StringArrayVar(&bitwardenItems, "item", viper.GetStringSlice("bw_items"), "Item name or ID, can be specified multiple times")
+	bitwardenCmd.Flags().BoolVar(&bitwardenSync, "sync", viper.GetBool("bw_sync"), "Sync the vault before reading the items")
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/injector"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// checking makes processSecrets report the secrets instead of running a command
+	checking    bool
+	checkSource string
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check <secrets manager command> [command flags]",
+	Short: "Check that every secret can be read without revealing the values or running a command",
+	Long: `Check runs a secrets manager command, like vault or aws, authenticating and resolving every path, wildcard,
+explicit secret:<KEY> and fully-qualified reference like the command would, and prints a table of the env vars
+that would be set with their source, version, value length and a short SHA-256 hash of the value, for example:
+
+` + "`secrets-consumer-env check vault --role app --path secret/app`" + `
+
+Nothing is run, the values are never printed. The version is the secret version read, when the secrets manager
+returns one. The exit code is non-zero when a key is missing, a reference can not be resolved, a secret key
+is already set in the environment with ` + "`--precedence error`" + ` or ` + "`--strict`" + ` finds a violation`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("secrets manager command is missing, like: check vault --role app --path secret/app")
+		}
+		if args[0] == cmd.Name() {
+			return errors.New("check can not check itself")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		checking = true
+		checkSource = args[0]
+		logOutput = os.Stderr
+		RootCmd.SetArgs(args)
+		if err := RootCmd.Execute(); err != nil {
+			exitWithError("error checking secrets", err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(checkCmd)
+
+	// the flags after the secrets manager command are its own
+	checkCmd.Flags().SetInterspersed(false)
+}
+
+// checkSecrets prints the env vars the secrets would set and exits non-zero on any missing one
+func checkSecrets(secretData map[string]interface{}, environ []string) {
+	injections, err := injector.PlanInjections(secretData, environ, checkSource, injectOptions())
+	if injections == nil && err != nil {
+		exitWithError("error checking secrets", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tVERSION\tLENGTH\tSHA256\tSTATUS")
+	failed := 0
+	for _, injection := range injections {
+		if injection.Err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\tmissing: %v\n", injection.Name, injection.Source, injection.Version, injection.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\tok\n", injection.Name, injection.Source, injection.Version, len(injection.Value), injection.Hash())
+	}
+	w.Flush()
+
+	if err != nil {
+		exitWithError("error checking secrets", err)
+	}
+	if failed > 0 {
+		log.Errorf("%d of %d secrets are missing", failed, len(injections))
+		os.Exit(1)
+	}
+	log.Infof("All %d secrets can be read", len(injections))
+}
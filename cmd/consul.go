@@ -0,0 +1,102 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	consul "github.com/doitintl/secrets-consumer-env/pkg/consul"
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	consulAddress    string
+	consulToken      string
+	consulDatacenter string
+	consulKeys       []string
+	consulCACert     string
+	consulClientCert string
+	consulClientKey  string
+)
+
+// consulCmd represents the consul command
+var consulCmd = &cobra.Command{
+	Use:   "consul",
+	Short: "Secrets Consumer for HashiCorp Consul KV",
+	Long: `Consul KV keys are read with the Consul HTTP API, a --key ending with a "/" is read as a prefix with every key
+below it, later keys override earlier ones.
+
+A value holding a JSON object is expanded into its keys, any other value is exported under the last segment of its key,
+for example config/payments/DB_PASSWORD is exported as DB_PASSWORD.
+
+The agent address, ACL token and TLS client authentication are read from the standard Consul environment variables
+CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN, CONSUL_CACERT, CONSUL_CLIENT_CERT and CONSUL_CLIENT_KEY or their flags,
+the ACL token needs` + " `key_prefix \"<prefix>\" { policy = \"read\" }` " + `on the keys`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(consulKeys) == 0 {
+			return errors.New("Consul key is missing, pass it via --key flag or set CONSUL_KEYS environment variable")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := &consul.Config{
+			Address:    consulAddress,
+			Token:      consulToken,
+			Datacenter: consulDatacenter,
+			Keys:       consulKeys,
+			TLS: tlsconfig.Config{
+				CACert:     consulCACert,
+				ClientCert: consulClientCert,
+				ClientKey:  consulClientKey,
+			},
+			Recorder: recorder,
+		}
+		client, err := consul.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating new Consul client", err)
+		}
+		log.Info("Using Consul KV")
+		secretData, err := consul.RetrieveSecret(client, cfg)
+		if err != nil {
+			exitWithError("error retrieving secrets from Consul KV", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(consulCmd)
+
+	viper.SetDefault("consul_http_addr", consul.DefaultAddress)
+	viper.SetDefault("consul_http_token", "")
+	viper.SetDefault("consul_datacenter", "")
+	viper.SetDefault("consul_keys", []string{})
+	viper.SetDefault("consul_cacert", "")
+	viper.SetDefault("consul_client_cert", "")
+	viper.SetDefault("consul_client_key", "")
+	viper.AutomaticEnv()
+
+	consulCmd.Flags().StringVar(&consulAddress, "address", viper.GetString("consul_http_addr"), "Consul HTTP API address")
+	consulCmd.Flags().StringVar(&consulToken, "token", viper.GetString("consul_http_token"), "Consul ACL token")
+	consulCmd.Flags().StringVar(&consulDatacenter, "datacenter", viper.GetString("consul_datacenter"), "Consul datacenter (default: the agent datacenter)")
+	consulCmd.Flags().StringArrayVar(&consulKeys, "key", viper.GetStringSlice("consul_keys"), "Consul key, or prefix ending with a \"/\", can be specified multiple times")
+	consulCmd.Flags().StringVar(&consulCACert, "ca-cert", viper.GetString("consul_cacert"), "CA certificate file path to verify the Consul server")
+	consulCmd.Flags().StringVar(&consulClientCert, "client-cert", viper.GetString("consul_client_cert"), "Client certificate file path for TLS client authentication")
+	consulCmd.Flags().StringVar(&consulClientKey, "client-key", viper.GetString("consul_client_key"), "Client key file path for TLS client authentication")
+}
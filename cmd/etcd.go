@@ -0,0 +1,109 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	etcd "github.com/doitintl/secrets-consumer-env/pkg/etcd"
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	etcdEndpoints  []string
+	etcdUser       string
+	etcdPassword   string
+	etcdKeys       []string
+	etcdCACert     string
+	etcdClientCert string
+	etcdClientKey  string
+)
+
+// etcdCmd represents the etcd command
+var etcdCmd = &cobra.Command{
+	Use:   "etcd",
+	Short: "Secrets Consumer for etcd",
+	Long: `etcd keys are read with the etcd v3 JSON gateway, a --key ending with a "/" is read as a prefix with every key
+below it, later keys override earlier ones.
+
+A value holding a JSON object is expanded into its keys, any other value is exported under the last segment of its key,
+for example /config/payments/DB_PASSWORD is exported as DB_PASSWORD.
+
+The endpoints, user and TLS client authentication are read from the etcdctl environment variables ETCDCTL_ENDPOINTS,
+ETCDCTL_USER (<user>:<password>), ETCDCTL_CACERT, ETCDCTL_CERT and ETCDCTL_KEY or their flags.
+
+With --client-cert-auth etcd rejects gateway requests with a client certificate holding a CommonName, authenticate
+with a certificate without one and the user`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(etcdKeys) == 0 {
+			return errors.New("etcd key is missing, pass it via --key flag or set ETCD_KEYS environment variable")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		user, password := etcdUser, etcdPassword
+		if split := strings.SplitN(etcdUser, ":", 2); len(split) == 2 && password == "" {
+			user, password = split[0], split[1]
+		}
+		cfg := &etcd.Config{
+			Endpoints: etcdEndpoints,
+			Username:  user,
+			Password:  password,
+			Keys:      etcdKeys,
+			TLS: tlsconfig.Config{
+				CACert:     etcdCACert,
+				ClientCert: etcdClientCert,
+				ClientKey:  etcdClientKey,
+			},
+			Recorder: recorder,
+		}
+		client, err := etcd.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating new etcd client", err)
+		}
+		log.Info("Using etcd")
+		secretData, err := etcd.RetrieveSecret(client, cfg)
+		if err != nil {
+			exitWithError("error retrieving secrets from etcd", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(etcdCmd)
+
+	viper.SetDefault("etcdctl_endpoints", []string{etcd.DefaultEndpoint})
+	viper.SetDefault("etcdctl_user", "")
+	viper.SetDefault("etcdctl_password", "")
+	viper.SetDefault("etcd_keys", []string{})
+	viper.SetDefault("etcdctl_cacert", "")
+	viper.SetDefault("etcdctl_cert", "")
+	viper.SetDefault("etcdctl_key", "")
+	viper.AutomaticEnv()
+
+	etcdCmd.Flags().StringSliceVar(&etcdEndpoints, "endpoints", viper.GetStringSlice("etcdctl_endpoints"), "Comma separated etcd client URLs")
+	etcdCmd.Flags().StringVar(&etcdUser, "user", viper.GetString("etcdctl_user"), "etcd user name, or <user>:<password>")
+	etcdCmd.Flags().StringVar(&etcdPassword, "password", viper.GetString("etcdctl_password"), "etcd user password")
+	etcdCmd.Flags().StringArrayVar(&etcdKeys, "key", viper.GetStringSlice("etcd_keys"), "etcd key, or prefix ending with a \"/\", can be specified multiple times")
+	etcdCmd.Flags().StringVar(&etcdCACert, "ca-cert", viper.GetString("etcdctl_cacert"), "CA certificate file path to verify the etcd server")
+	etcdCmd.Flags().StringVar(&etcdClientCert, "client-cert", viper.GetString("etcdctl_cert"), "Client certificate file path for TLS client authentication")
+	etcdCmd.Flags().StringVar(&etcdClientKey, "client-key", viper.GetString("etcdctl_key"), "Client key file path for TLS client authentication")
+}
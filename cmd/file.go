@@ -0,0 +1,90 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	file "github.com/doitintl/secrets-consumer-env/pkg/file"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	filePaths      []string
+	fileFormat     string
+	fileSOPS       string
+	sopsBinary     string
+	sopsAgeKeyFile string
+)
+
+// fileCmd represents the file command
+var fileCmd = &cobra.Command{
+	Use:   "file",
+	Short: "Secrets Consumer for local dotenv, JSON or YAML files",
+	Long: `Local files are read without any network service, for local development and air-gapped deployments,
+the keys of every --path are exported as environment variables, later files override the keys of earlier ones.
+
+The format is picked from the file extension (.env, .json, .yaml, .yml) or set with --format [dotenv, json, yaml].
+
+Files encrypted with [SOPS](https://github.com/mozilla/sops) are decrypted with the sops binary, by default when the
+file holds SOPS metadata (--sops auto), age keys are read from --sops-age-key-file (or SOPS_AGE_KEY_FILE) and
+PGP keys from the gpg keyring` +
+
+		"\n\n```" + `bash
+secrets-consumer-env file --path secrets.enc.yaml --sops-age-key-file ~/.config/sops/age/keys.txt -- ./app` +
+		"\n```",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(filePaths) == 0 {
+			return errors.New("File path is missing, pass it via --path flag or set SECRETS_FILES environment variable")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := &file.Config{
+			Paths:      filePaths,
+			Format:     fileFormat,
+			SOPS:       fileSOPS,
+			SOPSBinary: sopsBinary,
+			AgeKeyFile: sopsAgeKeyFile,
+			Recorder:   recorder,
+		}
+		log.Info("Using local secret files")
+		secretData, err := file.RetrieveSecret(cfg)
+		if err != nil {
+			exitWithError("error reading secrets from files", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(fileCmd)
+
+	viper.SetDefault("secrets_files", []string{})
+	viper.SetDefault("secrets_file_format", "")
+	viper.SetDefault("sops", file.SOPSAuto)
+	viper.SetDefault("sops_binary", file.DefaultSOPSBinary)
+	viper.SetDefault("sops_age_key_file", "")
+	viper.AutomaticEnv()
+
+	fileCmd.Flags().StringArrayVar(&filePaths, "path", viper.GetStringSlice("secrets_files"), "Path of a dotenv, JSON or YAML secrets file, can be specified multiple times")
+	fileCmd.Flags().StringVar(&fileFormat, "format", viper.GetString("secrets_file_format"), "Format of the files [dotenv, json, yaml] (default: from the file extension)")
+	fileCmd.Flags().StringVar(&fileSOPS, "sops", viper.GetString("sops"), "Decrypt the files with SOPS [auto, always, never]")
+	fileCmd.Flags().StringVar(&sopsBinary, "sops-binary", viper.GetString("sops_binary"), "Path of the sops executable")
+	fileCmd.Flags().StringVar(&sopsAgeKeyFile, "sops-age-key-file", viper.GetString("sops_age_key_file"), "age identities file for decrypting SOPS files (default: SOPS_AGE_KEY_FILE)")
+}
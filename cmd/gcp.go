@@ -31,42 +31,90 @@ var (
 	projectID                    string
 	secretNameGCP                string
 	secretVersionGCP             string
+	secretLocationGCP            string
 	googleApplicationCredentials string
+	gcpSecretConfigs             []string
+	impersonateServiceAccount    string
+	impersonateDelegates         []string
+	gcpLabels                    []string
+	gcpFilter                    string
+	gcpNameLabel                 string
 )
 
 // gcpCmd represents the gcp command
 var gcpCmd = &cobra.Command{
 	Use:   "gcp",
 	Short: "Secrets Consumer for GCP Secret Manager",
-	Long: `GCP secrets manager can hold secrets in plain text, it does not bind a format, in order to work with this tool
-you must use a JSON format for your secrets.
+	Long: `GCP secrets manager can hold secrets in plain text, it does not bind a format, a secret passed with --secret-name
+must use a JSON format, its keys are exported as environment variables.
 
-GCP secrets manager can hold a numerical version number, and you can specify it using ` + "`SECRET_VERSION`" + `
+Multiple secrets can be passed with --secret-config, JSON secrets are expanded into keys and plain text secrets
+are exported as is under the given env var name or the secret name, the secrets are fetched concurrently:
+'{"name": "app-config"}' '{"name": "db-password", "format": "raw", "env": "DB_PASSWORD"}'
 
-This app is working using the [Application Default Credentials](https://cloud.google.com/sdk/gcloud/reference/auth/application-default/login)
+Secrets can also be discovered with ` + "`--label app=checkout --label env=prod`" + ` and / or a ` + "`--filter`" + ` expression,
+the latest enabled version of every matching secret is exported as is, under the secret ID or the value of the
+` + "`--name-label`" + ` label of the secret, both upper cased with dashes and dots replaced with underscores, so new secrets show up without
+changing the command, explicitly configured secrets override discovered ones.
 
-you must either use ` + "`export GOOGLE_APPLICATION_CREDENTIALS=<path-to-service-account-json-file>` " + `or use the command` +
+GCP secrets manager can hold a numerical version number, and you can specify it using ` + "`SECRET_VERSION`" + `,
+either a version number, ` + "`latest`" + ` or a version alias.
+
+The secret name can also be a full resource name like ` + "`projects/<project>/secrets/<secret>/versions/<version>`" + `,
+regional secrets are accessed with ` + "`--location`" + ` (or a ` + "`projects/<project>/locations/<location>/secrets/<secret>`" + ` name)
+through the location-specific endpoint.
+
+This app is working using the [Application Default Credentials](https://cloud.google.com/docs/authentication/production),
+a service account key file is optional, the credentials are looked up in order from:
+
+* ` + "`--google-application-credentials`" + ` or ` + "`GOOGLE_APPLICATION_CREDENTIALS=<path-to-service-account-json-file>`" + `
+* the user credentials of the command` +
 
 		"\n\n```" + `bash
 gcloud auth application-default login` +
 		"\n```" + `
 
-The logged in serviceAccount or User must have the permissions/role` + " `roles/secretmanager.secretAccessor` " + `to the secret`,
+* the metadata server on GKE (Workload Identity), GCE, Cloud Run and Cloud Functions
+
+To access the secrets as another service account use ` + "`--impersonate-service-account`" + `, with ` + "`--impersonate-delegates`" + `
+for a chain of service accounts, each caller in the chain must have` + " `roles/iam.serviceAccountTokenCreator` " + `on the next one.
+
+The logged in (or impersonated) serviceAccount or User must have the permissions/role` + " `roles/secretmanager.secretAccessor` " + `to the secret`,
 	Args: validateCmdFlags,
 	Run: func(cmd *cobra.Command, args []string) {
 		var (
 			secretData map[string]interface{}
 			err        error
 		)
-		client, err := gcp.NewSecretManagerClient()
+		location := secretLocationGCP
+		if location == "" {
+			location = gcp.LocationFromName(secretNameGCP)
+		}
+		secretsConfigList, err := gcp.ConfigureSecrets(gcpSecretConfigs)
 		if err != nil {
-			exitWithError("error creating new GCP Secret Manager client", err)
+			exitWithError("error configuring GCP secrets", err)
+		}
+		labels, err := gcp.ParseLabels(gcpLabels)
+		if err != nil {
+			exitWithError("error parsing GCP secret labels", err)
 		}
 		cfg := &gcp.Config{
+			SecretsConfigList:            secretsConfigList,
 			ProjectID:                    projectID,
 			SecretName:                   secretNameGCP,
+			Location:                     location,
 			SecretVersion:                secretVersionGCP,
 			GoogleApplicationCredentials: googleApplicationCredentials,
+			ImpersonateServiceAccount:    impersonateServiceAccount,
+			ImpersonateDelegates:         impersonateDelegates,
+			Labels:                       labels,
+			Filter:                       gcpFilter,
+			NameLabel:                    gcpNameLabel,
+			Recorder:                     recorder,
+		}
+		client, err := gcp.NewSecretManagerClient(cfg)
+		if err != nil {
+			exitWithError("error creating new GCP Secret Manager client", err)
 		}
 		log.Info("Using GCP Secret Manager")
 		secretData, err = gcp.RetrieveSecret(client, cfg)
@@ -83,21 +131,38 @@ func init() {
 	viper.SetDefault("project_id", "")
 	viper.SetDefault("secret_name", "")
 	viper.SetDefault("secret_version", "latest")
+	viper.SetDefault("secret_location", "")
 	viper.SetDefault("google_application_credentials", "")
+	viper.SetDefault("impersonate_service_account", "")
+	viper.SetDefault("impersonate_delegates", []string{})
+	viper.SetDefault("secret_labels", []string{})
+	viper.SetDefault("secret_filter", "")
+	viper.SetDefault("secret_name_label", "")
 	viper.AutomaticEnv()
 
 	gcpCmd.Flags().StringVar(&projectID, "project-id", viper.GetString("project_id"), "GCP Project ID the Secret Manager is on")
-	gcpCmd.Flags().StringVar(&secretNameGCP, "secret-name", viper.GetString("secret_name"), "GCP Secret Name")
-	gcpCmd.Flags().StringVar(&secretVersionGCP, "secret-version", viper.GetString("secret_version"), "GCP Secret Version (default: latest)")
-	gcpCmd.Flags().StringVarP(&googleApplicationCredentials, "google-application-credentials", "a", viper.GetString("google_application_credentials"), "The file path to the GCP service account json file with permission to the secret")
+	gcpCmd.Flags().StringVar(&secretNameGCP, "secret-name", viper.GetString("secret_name"), "GCP Secret Name or full resource name (projects/<project>/secrets/<secret>/versions/<version>)")
+	gcpCmd.Flags().StringVar(&secretVersionGCP, "secret-version", viper.GetString("secret_version"), "GCP Secret Version number, latest or a version alias (default: latest)")
+	gcpCmd.Flags().StringVar(&secretLocationGCP, "location", viper.GetString("secret_location"), "GCP location of a regional secret, e.g. us-central1 (default: global secret)")
+
+	// Multiple secrets via JSON string
+	gcpCmd.Flags().StringArrayVarP(
+		&gcpSecretConfigs,
+		"secret-config",
+		"",
+		[]string{},
+		"multiple secrets in JSON string like: '{\"name\": \"db-password\", \"version\": \"3\", \"format\": \"raw\", \"env\": \"DB_PASSWORD\"}' can be specified a multiple times",
+	)
+	gcpCmd.Flags().StringArrayVar(&gcpLabels, "label", viper.GetStringSlice("secret_labels"), "discover secrets with the label key=value, can be specified multiple times")
+	gcpCmd.Flags().StringVar(&gcpFilter, "filter", viper.GetString("secret_filter"), "discover secrets matching a Secret Manager list filter expression, e.g. 'name:checkout-'")
+	gcpCmd.Flags().StringVar(&gcpNameLabel, "name-label", viper.GetString("secret_name_label"), "label holding the env var name of a discovered secret (default: the secret ID)")
+	gcpCmd.Flags().StringVarP(&googleApplicationCredentials, "google-application-credentials", "a", viper.GetString("google_application_credentials"), "The file path to the GCP service account json file with permission to the secret (default: Application Default Credentials)")
+	gcpCmd.Flags().StringVar(&impersonateServiceAccount, "impersonate-service-account", viper.GetString("impersonate_service_account"), "Email of a service account to impersonate for accessing the secrets")
+	gcpCmd.Flags().StringSliceVar(&impersonateDelegates, "impersonate-delegates", viper.GetStringSlice("impersonate_delegates"), "Comma separated chain of service accounts emails to impersonate before --impersonate-service-account")
 }
 
-func validateGCPConfig(projectID, credsPath string) error {
+func validateGCPCredentials(credsPath string) error {
 	var err error
-	if projectID == "" {
-		return errors.New("Project ID is missing, pass it via --project-id flag or set PROJECT_ID environment variable")
-	}
-
 	if credsPath == "" {
 		return errors.New("Google Application Credentials Service Account JSON file location is missing, pass it via --google-application-credentials flag or set CREDS_PATH environment variable")
 	}
@@ -115,14 +180,31 @@ func validateGCPConfig(projectID, credsPath string) error {
 	return nil
 }
 
+// setGCPCredentials points the Application Default Credentials to the key file when one is given
+func setGCPCredentials(credsPath string) error {
+	if credsPath == "" {
+		log.Debug("No service account key file, using the Application Default Credentials")
+		return nil
+	}
+	return validateGCPCredentials(credsPath)
+}
+
 func validateCmdFlags(cmd *cobra.Command, args []string) error {
-	err := validateGCPConfig(projectID, googleApplicationCredentials)
-	if err != nil {
-		return err
+	discovery := len(gcpLabels) > 0 || gcpFilter != ""
+	if secretNameGCP == "" && len(gcpSecretConfigs) == 0 && !discovery {
+		return errors.New("Secret Name is missing, pass it via --secret-name flag or set SECRET_NAME environment variable, you can also use --secret-config, --label or --filter flags")
 	}
-	if secretNameGCP == "" {
-		return errors.New("Secret Name is missing, pass it via --secret-name flag or set SECRET_NAME environment variable")
+	if discovery && projectID == "" {
+		return errors.New("Project ID is missing, pass it via --project-id flag or set PROJECT_ID environment variable to discover secrets")
 	}
 
-	return nil
+	if len(impersonateDelegates) > 0 && impersonateServiceAccount == "" {
+		return errors.New("--impersonate-delegates requires --impersonate-service-account")
+	}
+
+	// a full resource name already holds the project
+	if projectID == "" && secretNameGCP != "" && !gcp.IsResourceName(secretNameGCP) {
+		return errors.New("Project ID is missing, pass it via --project-id flag or set PROJECT_ID environment variable")
+	}
+	return setGCPCredentials(googleApplicationCredentials)
 }
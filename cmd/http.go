@@ -0,0 +1,101 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/httpjson"
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	httpURL             string
+	httpBearerTokenFile string
+	httpUsername        string
+	httpPassword        string
+	httpPointer         string
+	httpCACert          string
+	httpClientCert      string
+	httpClientKey       string
+)
+
+// httpCmd represents the http command
+var httpCmd = &cobra.Command{
+	Use:   "http",
+	Short: "Secrets Consumer for a generic HTTP JSON API",
+	Long: `The secrets are read with a GET request to --url returning a JSON document, the object at the --pointer
+JSON pointer (RFC 6901), like /data/secrets, is exported, the whole document is exported without one.
+
+The request is authenticated with a bearer token read from --bearer-token-file on every start,
+basic authentication with --username and --password, or a TLS client certificate with --client-cert and --client-key`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if httpURL == "" {
+			return errors.New("URL is missing, pass it via --url flag or set HTTP_SECRETS_URL environment variable")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := &httpjson.Config{
+			URL:             httpURL,
+			BearerTokenFile: httpBearerTokenFile,
+			Username:        httpUsername,
+			Password:        httpPassword,
+			Pointer:         httpPointer,
+			TLS: tlsconfig.Config{
+				CACert:     httpCACert,
+				ClientCert: httpClientCert,
+				ClientKey:  httpClientKey,
+			},
+		}
+		client, err := httpjson.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating new HTTP client", err)
+		}
+		log.Info("Using HTTP JSON API")
+		secretData, err := httpjson.RetrieveSecret(client, cfg)
+		if err != nil {
+			exitWithError("error retrieving secrets from HTTP", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(httpCmd)
+
+	viper.SetDefault("http_secrets_url", "")
+	viper.SetDefault("http_bearer_token_file", "")
+	viper.SetDefault("http_username", "")
+	viper.SetDefault("http_password", "")
+	viper.SetDefault("http_json_pointer", "")
+	viper.SetDefault("http_cacert", "")
+	viper.SetDefault("http_client_cert", "")
+	viper.SetDefault("http_client_key", "")
+	viper.AutomaticEnv()
+
+	httpCmd.Flags().StringVar(&httpURL, "url", viper.GetString("http_secrets_url"), "URL of the JSON secrets document")
+	httpCmd.Flags().StringVar(&httpBearerTokenFile, "bearer-token-file", viper.GetString("http_bearer_token_file"), "File path of the bearer token")
+	httpCmd.Flags().StringVar(&httpUsername, "username", viper.GetString("http_username"), "Username for basic authentication")
+	httpCmd.Flags().StringVar(&httpPassword, "password", viper.GetString("http_password"), "Password for basic authentication")
+	httpCmd.Flags().StringVar(&httpPointer, "pointer", viper.GetString("http_json_pointer"), "JSON pointer to the secrets object, like /data/secrets (default: the whole document)")
+	httpCmd.Flags().StringVar(&httpCACert, "ca-cert", viper.GetString("http_cacert"), "CA certificate file path to verify the server")
+	httpCmd.Flags().StringVar(&httpClientCert, "client-cert", viper.GetString("http_client_cert"), "Client certificate file path for TLS client authentication")
+	httpCmd.Flags().StringVar(&httpClientKey, "client-key", viper.GetString("http_client_key"), "Client key file path for TLS client authentication")
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	kubernetes "github.com/doitintl/secrets-consumer-env/pkg/kubernetes"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	k8sSecrets   []string
+	k8sNamespace string
+	k8sAPIServer string
+	k8sTokenPath string
+	k8sCACert    string
+)
+
+// kubernetesCmd represents the kubernetes command
+var kubernetesCmd = &cobra.Command{
+	Use:   "kubernetes",
+	Short: "Secrets Consumer for Kubernetes Secrets",
+	Long: `Kubernetes Secrets are read from the API server with the in-cluster service account token, the decoded keys
+of every --secret are exported as environment variables, later secrets override the keys of earlier ones.
+
+A secret is referenced by name in the service account namespace (or --namespace) or as <namespace>/<name>:
+
+--secret app-config --secret shared/stripe
+
+The service account needs a Role granting` + " `get` " + `on the secrets, for example with` + " `resourceNames` " + `set to the secrets names.
+
+Outside of a cluster pass the API server with --api-server, the service account token with --token-path and the
+API server CA certificate with --ca-cert`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(k8sSecrets) == 0 {
+			return errors.New("Secret name is missing, pass it via --secret flag or set KUBERNETES_SECRETS environment variable")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var (
+			secretData map[string]interface{}
+			err        error
+		)
+		namespace := k8sNamespace
+		if namespace == "" {
+			namespace, err = kubernetes.ServiceAccountNamespace(kubernetes.DefaultNamespacePath)
+			if err != nil {
+				log.Debug(err)
+			}
+		}
+		cfg := &kubernetes.Config{
+			APIServer:   k8sAPIServer,
+			TokenPath:   k8sTokenPath,
+			CACertPath:  k8sCACert,
+			Namespace:   namespace,
+			SecretNames: k8sSecrets,
+			Recorder:    recorder,
+		}
+		client, err := kubernetes.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating new Kubernetes API client", err)
+		}
+		log.Info("Using Kubernetes Secrets")
+		secretData, err = kubernetes.RetrieveSecret(client, cfg)
+		if err != nil {
+			exitWithError("error retrieving secrets from Kubernetes", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(kubernetesCmd)
+
+	viper.SetDefault("kubernetes_secrets", []string{})
+	viper.SetDefault("kubernetes_namespace", "")
+	viper.SetDefault("kubernetes_api_server", "")
+	viper.SetDefault("token_path", kubernetes.DefaultTokenPath)
+	viper.SetDefault("kubernetes_ca_cert", "")
+	viper.AutomaticEnv()
+
+	kubernetesCmd.Flags().StringArrayVar(&k8sSecrets, "secret", viper.GetStringSlice("kubernetes_secrets"), "Kubernetes Secret name or <namespace>/<name>, can be specified multiple times")
+	kubernetesCmd.Flags().StringVar(&k8sNamespace, "namespace", viper.GetString("kubernetes_namespace"), "Namespace of the secrets (default: the service account namespace)")
+	kubernetesCmd.Flags().StringVar(&k8sAPIServer, "api-server", viper.GetString("kubernetes_api_server"), "Kubernetes API server URL (default: in-cluster KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT)")
+	kubernetesCmd.Flags().StringVar(&k8sTokenPath, "token-path", viper.GetString("token_path"), "Kubernetes service account JWT token file path")
+	kubernetesCmd.Flags().StringVar(&k8sCACert, "ca-cert", viper.GetString("kubernetes_ca_cert"), "Kubernetes API server CA certificate file path (default: the service account CA in-cluster, the system roots with --api-server)")
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/onepassword"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	onePasswordHost  string
+	onePasswordToken string
+	onePasswordItems []string
+)
+
+// onePasswordCmd represents the onepassword command
+var onePasswordCmd = &cobra.Command{
+	Use:   "onepassword",
+	Short: "Secrets Consumer for 1Password Connect",
+	Long: `1Password items are read with the 1Password Connect REST API, an --item is a <vault>/<item> reference
+with the vault and item by name or UUID, later items override earlier ones.
+
+Every field with a value is exported under its label, for example a "db password" field is exported as DB_PASSWORD.
+
+The Connect server and its access token are read from OP_CONNECT_HOST and OP_CONNECT_TOKEN or their flags`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if onePasswordHost == "" {
+			return errors.New("1Password Connect host is missing, pass it via --host flag or set OP_CONNECT_HOST environment variable")
+		}
+		if onePasswordToken == "" {
+			return errors.New("1Password Connect token is missing, pass it via --token flag or set OP_CONNECT_TOKEN environment variable")
+		}
+		if len(onePasswordItems) == 0 {
+			return errors.New("1Password item is missing, pass it via --item flag or set OP_ITEMS environment variable")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := &onepassword.Config{
+			Host:     onePasswordHost,
+			Token:    onePasswordToken,
+			Items:    onePasswordItems,
+			Recorder: recorder,
+		}
+		client, err := onepassword.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating new 1Password Connect client", err)
+		}
+		log.Info("Using 1Password Connect")
+		secretData, err := onepassword.RetrieveSecret(client, cfg)
+		if err != nil {
+			exitWithError("error retrieving secrets from 1Password Connect", err)
+		}
+		processSecrets(secretData, args)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(onePasswordCmd)
+
+	viper.SetDefault("op_connect_host", "")
+	viper.SetDefault("op_connect_token", "")
+	viper.SetDefault("op_items", []string{})
+	viper.AutomaticEnv()
+
+	onePasswordCmd.Flags().StringVar(&onePasswordHost, "host", viper.GetString("op_connect_host"), "1Password Connect server URL")
+	onePasswordCmd.Flags().StringVar(&onePasswordToken, "token", viper.GetString("op_connect_token"), "1Password Connect access token")
+	onePasswordCmd.Flags().StringArrayVar(&onePasswordItems, "item", viper.GetStringSlice("op_items"), "Item as <vault>/<item>, can be specified multiple times")
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	aws "github.com/doitintl/secrets-consumer-env/pkg/aws"
+	gcp "github.com/doitintl/secrets-consumer-env/pkg/gcp"
+	"github.com/doitintl/secrets-consumer-env/pkg/injector"
+	vault "github.com/doitintl/secrets-consumer-env/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// referenceResolvers returns the resolvers of the references by source, each configured by the
+// env vars of its source command, a source client is only created when its resolver is called
+func referenceResolvers() map[string]injector.Resolver {
+	return map[string]injector.Resolver{
+		"vault": vaultReferenceResolver(
+			&vault.Config{
+				Role:              viper.GetString("vault_role"),
+				TokenPath:         viper.GetString("token_path"),
+				Backend:           viper.GetString("vault_backend"),
+				KubernetesBackend: viper.GetString("kubernetes_backend"),
+			},
+			&vault.GCPBackendConfig{
+				Project:                   viper.GetString("project_id"),
+				CredsPath:                 viper.GetString("google_application_credentials"),
+				LoginType:                 viper.GetString("gcp_login_type"),
+				MountPath:                 viper.GetString("gcp_mount_path"),
+				ImpersonateServiceAccount: viper.GetString("impersonate_service_account"),
+				ImpersonateDelegates:      viper.GetStringSlice("impersonate_delegates"),
+				JWTExpiry:                 viper.GetDuration("gcp_jwt_expiry"),
+			},
+		),
+		"aws": awsReferenceResolver(&aws.Config{
+			Region:               viper.GetString("region"),
+			RoleARN:              viper.GetString("role_arn"),
+			SecretName:           awsSDK.String(""),
+			EndpointURL:          viper.GetString("aws_endpoint_url"),
+			STSEndpoint:          viper.GetString("aws_sts_endpoint"),
+			UseFIPSEndpoint:      viper.GetBool("aws_use_fips_endpoint"),
+			UseDualStackEndpoint: viper.GetBool("aws_use_dualstack_endpoint"),
+			MaxRetries:           viper.GetInt("aws_max_retries"),
+			Timeout:              viper.GetDuration("aws_timeout"),
+		}),
+		"gcp": gcpReferenceResolver(&gcp.Config{
+			ImpersonateServiceAccount: viper.GetString("impersonate_service_account"),
+			ImpersonateDelegates:      viper.GetStringSlice("impersonate_delegates"),
+		}),
+	}
+}
+
+func vaultReferenceResolver(cfg *vault.Config, gcpCfg *vault.GCPBackendConfig) injector.Resolver {
+	return func(paths []string) (map[string]interface{}, error) {
+		if cfg.Role == "" {
+			return nil, errors.New("Vault role is missing, set the VAULT_ROLE environment variable to resolve vault references")
+		}
+		client, err := vault.NewClientWithConfig(vaultapi.DefaultConfig(), cfg, gcpCfg)
+		if err != nil {
+			return nil, err
+		}
+		return vault.ReadPaths(client.Client, paths)
+	}
+}
+
+func awsReferenceResolver(cfg *aws.Config) injector.Resolver {
+	return func(names []string) (map[string]interface{}, error) {
+		return aws.ResolveSecretStrings(cfg, names)
+	}
+}
+
+// gcpReferenceResolver accesses the secret versions with a client per location,
+// regional secrets are served from their location endpoint
+func gcpReferenceResolver(cfg *gcp.Config) injector.Resolver {
+	return func(names []string) (map[string]interface{}, error) {
+		byLocation := make(map[string][]string)
+		for _, name := range names {
+			location := gcp.LocationFromName(name)
+			byLocation[location] = append(byLocation[location], name)
+		}
+
+		secrets := make(map[string]interface{}, len(names))
+		for location, locationNames := range byLocation {
+			client, err := gcp.NewSecretManagerClient(&gcp.Config{
+				Location:                  location,
+				ImpersonateServiceAccount: cfg.ImpersonateServiceAccount,
+				ImpersonateDelegates:      cfg.ImpersonateDelegates,
+			})
+			if err != nil {
+				return nil, err
+			}
+			log.Debugf("Accessing %d GCP secret versions", len(locationNames))
+			locationSecrets, err := gcp.AccessSecretVersions(client, locationNames)
+			client.Close()
+			if err != nil {
+				return nil, err
+			}
+			for name, secret := range locationSecrets {
+				secrets[name] = secret
+			}
+		}
+		return secrets, nil
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2020 DoiT International <ami.mahloof@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/render"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	renderFormat string
+	renderOutput string
+	renderAll    bool
+	// rendering makes processSecrets write the secrets instead of running a command
+	rendering bool
+)
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render [flags] <secrets manager command> [command flags]",
+	Short: "Write the secrets to a file or stdout instead of running a command",
+	Long: `Render runs a secrets manager command, like vault or aws, with the same fetch and injection of the secrets,
+but writes the resulting variables to --output (stdout by default) instead of running a command,
+for processes that can not be wrapped like systemd units or a docker-compose env_file, for example:
+
+` + "`secrets-consumer-env render --format dotenv --output /run/app/.env vault --role app --path secret/app`" + `
+
+The formats are dotenv, json, yaml and export (a shell script of export statements), values are quoted and escaped,
+multi-line dotenv values are written on one line with \n escapes. The file is written atomically with 0600 permissions.
+
+Only the injected secret variables are written, --all writes the whole process environment`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("secrets manager command is missing, like: render --format dotenv vault --role app --path secret/app")
+		}
+		if args[0] == cmd.Name() {
+			return errors.New("render can not render itself")
+		}
+		for _, format := range render.Formats {
+			if renderFormat == format {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown format %q, pass one of %s via --format flag", renderFormat, strings.Join(render.Formats, ", "))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		rendering = true
+		if renderOutput == "" || renderOutput == "-" {
+			logOutput = os.Stderr
+		}
+		RootCmd.SetArgs(args)
+		if err := RootCmd.Execute(); err != nil {
+			exitWithError("error rendering secrets", err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(renderCmd)
+
+	viper.SetDefault("render_format", render.FormatDotenv)
+	viper.SetDefault("render_output", "")
+	viper.AutomaticEnv()
+
+	// the flags after the secrets manager command are its own
+	renderCmd.Flags().SetInterspersed(false)
+	renderCmd.Flags().StringVar(&renderFormat, "format", viper.GetString("render_format"), "Output format: dotenv, json, yaml or export")
+	renderCmd.Flags().StringVarP(&renderOutput, "output", "o", viper.GetString("render_output"), "Output file path (default: stdout)")
+	renderCmd.Flags().BoolVar(&renderAll, "all", false, "Write the whole process environment, not only the injected secrets")
+}
+
+// renderSecrets writes the sanitized environment entries that were not already in the environment
+func renderSecrets(sanitized, environ []string) {
+	entries := sanitized
+	if !renderAll {
+		existing := make(map[string]bool, len(environ))
+		for _, env := range environ {
+			existing[env] = true
+		}
+		entries = nil
+		for _, env := range sanitized {
+			if !existing[env] {
+				entries = append(entries, env)
+			}
+		}
+	}
+
+	variables := render.ParseEnviron(entries)
+	if err := render.WriteFile(renderOutput, renderFormat, variables); err != nil {
+		exitWithError("error writing secrets", err)
+	}
+	if renderOutput != "" && renderOutput != "-" {
+		log.Infof("Wrote %d variables to %s", len(variables), renderOutput)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"syscall"
 
 	"github.com/doitintl/secrets-consumer-env/pkg/injector"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
 	"github.com/doitintl/secrets-consumer-env/pkg/version"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
@@ -35,16 +36,48 @@ import (
 
 var cfgFile string
 
-//The verbose flag value
+// The verbose flag value
 var v string
 var command string
 
+// strict fails on secret key collisions and invalid env var names
+var strict bool
+
+// precedence between the existing env vars and the secret keys
+var precedence string
+
+// include and exclude glob patterns of the exported secret keys
+var (
+	includeKeys []string
+	excludeKeys []string
+)
+
+// scrubbing of the env vars inherited by the command
+var (
+	scrubKeys       []string
+	cleanEnv        bool
+	passthroughKeys []string
+)
+
+// flatten exports nested secret objects as PARENT_CHILD keys
+var flatten bool
+
+// provider is the name of the command fetching the secrets, its env vars are scrubbed
+var provider string
+
+// recorder records the secret keys set by more than one secret of the command for --strict,
+// and the key versions for check
+var recorder = &merge.Recorder{}
+
+// logOutput is where the logs are written, stderr when the secrets are rendered to stdout
+var logOutput io.Writer = os.Stdout
+
 // var args []string
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "secrets-consumer-env",
-	Short: "Consume secrets from AWS, GCP or Hashicorp Vault",
+	Short: "Consume secrets from AWS, GCP, Azure, Hashicorp Vault or Kubernetes",
 	Long: `There are a few secret managers that holds secrets, the problem becomes how to consume these secrets
 securely.
 
@@ -64,15 +97,47 @@ This tool works with the following secrets managers:
 
 * GCP Secret Manager
 * AWS Secret Manager
+* Azure Key Vault
 * Hashicorp Vault
   * Kubernetes backend login (Default)
   * GCP backend login
+* Kubernetes Secrets
+* HashiCorp Consul KV
+* etcd
+* 1Password Connect
+* Bitwarden and Vaultwarden
+* Generic HTTP JSON APIs
+* Local dotenv, JSON or YAML files, optionally encrypted with SOPS
 
 ### CLI Commands
 
 * ` + "`aws` " + ` - enable the AWS Secret Manager
 * ` + "`gcp` " + ` - enable the GCP Secret Manager
+* ` + "`azure` " + ` - enable the Azure Key Vault
 * ` + "`vault` " + ` - enable the Vault Secret Manager
+* ` + "`kubernetes` " + ` - enable the Kubernetes Secrets
+* ` + "`file` " + ` - enable the local secret files
+* ` + "`consul` " + ` - enable the Consul KV
+* ` + "`etcd` " + ` - enable etcd
+* ` + "`onepassword` " + ` - enable 1Password Connect
+* ` + "`bitwarden` " + ` - enable Bitwarden and Vaultwarden
+* ` + "`http` " + ` - enable a generic HTTP JSON API
+* ` + "`check` " + ` - check that every secret can be read, printing lengths and hashes instead of the values
+* ` + "`render` " + ` - write the secrets to a dotenv, JSON, YAML or shell export file instead of running a command
+
+### Secret references
+
+Env vars can reference a secret in its own source with every command, each reference is resolved when the
+process starts, with one batch per source and a source client only created when it is referenced:
+
+* ` + "`DB_PASS=vault:secret/data/db#password`" + ` - the password key of the Vault secret path
+* ` + "`DB_PASS=aws:prod/db#password`" + ` - the password key of the AWS Secrets Manager secret name or ARN
+* ` + "`DB_PASS=gcp:projects/p/secrets/db/versions/3`" + ` - the GCP secret version, the latest version without /versions/<version>
+
+A reference has a path with a / or a #<key>, unlike the ` + "`vault:<KEY>`" + ` and ` + "`secret:<KEY>`" + ` lookups of the fetched secret keys,
+a value shaped like a reference that is not a valid one fails. Without a #<key> the whole secret is used, the value can be
+piped through transforms like ` + "`TLS_KEY=vault:secret/data/tls#key|base64decode`" + `. The sources are configured by the env vars
+of their commands, like VAULT_ROLE or REGION, not their flags.
 
 **Note: The double dash symbol “–-” is used to separate the arguments you want to pass to the command from the secrets-consumer-env arguments.**
 
@@ -91,7 +156,8 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	fmt.Printf("Secrets Consumer Env Version: %s Commit: %s\n\n", version.GetVersion(), version.GetGitCommitID())
+	// diagnostics go to stderr, stdout can carry rendered secrets
+	fmt.Fprintf(os.Stderr, "Secrets Consumer Env Version: %s Commit: %s\n\n", version.GetVersion(), version.GetGitCommitID())
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
@@ -103,10 +169,28 @@ func init() {
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	// RootCmd.PersistentFlags().StringVarP(&command, "command", "c", "", "Command to be execute post secret injection")
 	// RootCmd.PersistentFlags().StringArrayVarP(&args, "args", "a", []string{}, "Command arguments that will be appended to the command")
+	viper.SetDefault("secrets_strict", false)
+	viper.SetDefault("secrets_precedence", injector.PrecedenceSecretsWin)
+	viper.SetDefault("secrets_include", []string{})
+	viper.SetDefault("secrets_exclude", []string{})
+	viper.SetDefault("secrets_scrub", []string{})
+	viper.SetDefault("secrets_clean_env", false)
+	viper.SetDefault("secrets_passthrough", []string{})
+	viper.SetDefault("secrets_flatten", false)
+	viper.AutomaticEnv()
+	RootCmd.PersistentFlags().BoolVar(&strict, "strict", viper.GetBool("secrets_strict"), "Fail on secret keys set by more than one secret, secret keys colliding with env vars and invalid env var names")
+	RootCmd.PersistentFlags().StringVar(&precedence, "precedence", viper.GetString("secrets_precedence"), "When a secret key is already an env var: secrets-win, env-wins or error")
+	RootCmd.PersistentFlags().StringArrayVar(&includeKeys, "include", viper.GetStringSlice("secrets_include"), "Glob pattern of the secret keys to export, like DB_*, can be specified multiple times (default: every key)")
+	RootCmd.PersistentFlags().StringArrayVar(&excludeKeys, "exclude", viper.GetStringSlice("secrets_exclude"), "Glob pattern of the secret keys not to export, can be specified multiple times")
+	RootCmd.PersistentFlags().StringArrayVar(&scrubKeys, "scrub", viper.GetStringSlice("secrets_scrub"), "Glob pattern of more env vars to remove from the command environment, like *_TOKEN, can be specified multiple times")
+	RootCmd.PersistentFlags().BoolVar(&cleanEnv, "clean-env", viper.GetBool("secrets_clean_env"), "Start the command from a minimal environment (PATH, HOME, USER, TZ, LANG, LC_*) plus the --passthrough env vars and the secrets")
+	RootCmd.PersistentFlags().StringArrayVar(&passthroughKeys, "passthrough", viper.GetStringSlice("secrets_passthrough"), "Glob pattern of env vars always kept in the command environment, can be specified multiple times")
+	RootCmd.PersistentFlags().BoolVar(&flatten, "flatten", viper.GetBool("secrets_flatten"), "Export the keys of nested secret objects as PARENT_CHILD env vars instead of a JSON value")
 	RootCmd.PersistentFlags().StringVarP(&v, "verbosity", "v", logrus.InfoLevel.String(), "Log level (debug, info, warn, error, fatal, panic")
 
 	RootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
-		if err := setUpLogs(os.Stdout, v); err != nil {
+		provider = cmd.Name()
+		if err := setUpLogs(logOutput, v); err != nil {
 			return err
 		}
 		return nil
@@ -134,11 +218,11 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
 
-//setUpLogs set the log output ans the log level
+// setUpLogs set the log output ans the log level
 func setUpLogs(out io.Writer, level string) error {
 	logrus.SetOutput(out)
 	logrus.SetFormatter(&log.TextFormatter{
@@ -156,12 +240,21 @@ func processSecrets(secretData map[string]interface{}, args []string) {
 	log.Info("Processing secrets from Secret Manager as environment variables")
 	var err error
 	environ := os.Environ()
+	if checking {
+		checkSecrets(secretData, environ)
+		return
+	}
 	sanitized := make(injector.SanitizedEnviron, 0, len(environ))
-	sanitized, err = injector.InjectSecrets(secretData, environ, sanitized)
+	sanitized, err = injector.InjectSecretsWithOptions(secretData, environ, sanitized, injectOptions())
 	if err != nil {
 		exitWithError("error injecting secrets", err)
 	}
 
+	if rendering {
+		renderSecrets(sanitized, environ)
+		return
+	}
+
 	if len(args) == 0 {
 		const msg = `
 		no command is given, secrets-consumer-env can't determine the entrypoint (command)
@@ -185,6 +278,26 @@ func processSecrets(secretData map[string]interface{}, args []string) {
 	}
 }
 
+// injectOptions returns the injection options of the flags
+func injectOptions() *injector.Options {
+	return &injector.Options{
+		Strict:     strict,
+		Conflicts:  recorder.Conflicts(),
+		Versions:   recorder.Versions(),
+		Precedence: precedence,
+		Include:    includeKeys,
+		Exclude:    excludeKeys,
+
+		Providers:   []string{provider},
+		Scrub:       scrubKeys,
+		CleanEnv:    cleanEnv,
+		Passthrough: passthroughKeys,
+		Flatten:     flatten,
+
+		Resolvers: referenceResolvers(),
+	}
+}
+
 func exitWithError(msg string, err error) {
 	log.Fatalf("%s: %v", msg, err)
 }
@@ -18,7 +18,9 @@ package cmd
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	vault "github.com/doitintl/secrets-consumer-env/pkg/vault"
 	vaultapi "github.com/hashicorp/vault/api"
@@ -38,7 +40,13 @@ var (
 	vaultUseSecretNamesAsKeys bool
 	GCPBackendProjectID       string
 	credsPath                 string
-	secretManager             string
+	gcpLoginType              string
+	gcpMountPath              string
+	gcpJWTExpiry              time.Duration
+	// the GCP backend impersonation, apart from the gcp command ones
+	vaultImpersonateServiceAccount string
+	vaultImpersonateDelegates      []string
+	secretManager                  string
 )
 
 // vaultCmd represents the vault command
@@ -53,6 +61,11 @@ secret path based on the secret backend version (v1 or v2)
 
 secrets-consumer-env can login to kubernetes backend (default) or GCP backend.
 
+The GCP backend logs in with the ` + "`iam`" + ` type (default), signing a JWT with the IAM Credentials API for the service account
+of --google-application-credentials, the Application Default Credentials or --impersonate-service-account (through the
+--impersonate-delegates chain), valid for --gcp-jwt-expiry, or the ` + "`gce`" + ` type using the identity token of the GCE instance metadata server
+with the ` + "`vault/<role>`" + ` audience, --gcp-mount-path sets the path the GCP auth method is enabled at (default: gcp).
+
 #### Ways to use Vault secrets:
 
 1. You can use Vault with a secret path that contains a JSON
@@ -95,24 +108,14 @@ A list of secrets names (keys) secrets/kvV2/service/:` + "\n" +
 
 4. you can use explicit secrets by using the following convention: ENV_NAME_TO_BE_EXPORTED="secret:<SECRET_KEY>",
    only these variables will be available to your given command/process.
+   The value can be piped through transforms, like "secret:tls_key|base64decode" or "secret:config|jsonpath:$.db.password|trim",
+   the transforms are base64decode, base64encode, jsonpath and trim, a | inside brackets or quotes like "jsonpath:$['a|b']" is not a pipe.
 
 5. Vault secret path can be either treated as a directory by using a trailing slash "/" or it can be use as a wildcard for example: db*, *db, *user*`,
 	Args: validateConfig,
 	Run: func(cmd *cobra.Command, args []string) {
 		var err error
 		secretData := make(map[string]interface{})
-		vaultCfg := &vault.Config{
-			Role:              vaultRole,
-			TokenPath:         tokenPath,
-			Backend:           vaultBackend,
-			KubernetesBackend: kubernetesBackend,
-		}
-		gcpCfg := &vault.GCPBackendConfig{
-			Project:        GCPBackendProjectID,
-			CredsPath:      credsPath,
-			ServiceAccount: "",
-		}
-
 		if vaultPath != "" {
 			var secretConfig vault.SecretConfigJSON
 			secretConfig.Path = vaultPath
@@ -122,7 +125,7 @@ A list of secrets names (keys) secrets/kvV2/service/:` + "\n" +
 			secretConfigs = append(secretConfigs, string(secretJSON))
 		}
 
-		client, err := vault.NewClientWithConfig(vaultapi.DefaultConfig(), vaultCfg, gcpCfg)
+		client, vaultCfg, err := newVaultClient()
 		if err != nil {
 			exitWithError("Error creating Vault client", err)
 		}
@@ -141,11 +144,47 @@ A list of secrets names (keys) secrets/kvV2/service/:` + "\n" +
 	},
 }
 
+// newVaultClient logs in to Vault with the backend of the vault flags
+func newVaultClient() (*vault.Client, *vault.Config, error) {
+	vaultCfg := &vault.Config{
+		Role:              vaultRole,
+		TokenPath:         tokenPath,
+		Backend:           vaultBackend,
+		KubernetesBackend: kubernetesBackend,
+		Recorder:          recorder,
+	}
+	gcpCfg := &vault.GCPBackendConfig{
+		Project:        GCPBackendProjectID,
+		CredsPath:      credsPath,
+		ServiceAccount: "",
+		LoginType:      gcpLoginType,
+		MountPath:      gcpMountPath,
+
+		ImpersonateServiceAccount: vaultImpersonateServiceAccount,
+		ImpersonateDelegates:      vaultImpersonateDelegates,
+		JWTExpiry:                 gcpJWTExpiry,
+	}
+	client, err := vault.NewClientWithConfig(vaultapi.DefaultConfig(), vaultCfg, gcpCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, vaultCfg, nil
+}
+
 func validateConfig(cmd *cobra.Command, args []string) error {
 	if vaultBackend == "gcp" {
-		err := validateGCPConfig(GCPBackendProjectID, credsPath)
-		if err != nil {
-			return err
+		switch gcpLoginType {
+		case vault.GCPLoginTypeIAM:
+			if len(vaultImpersonateDelegates) > 0 && vaultImpersonateServiceAccount == "" {
+				return errors.New("--impersonate-delegates requires --impersonate-service-account")
+			}
+			err := setGCPCredentials(credsPath)
+			if err != nil {
+				return err
+			}
+		case vault.GCPLoginTypeGCE:
+		default:
+			return fmt.Errorf("unknown GCP login type %q, pass iam or gce via --gcp-login-type flag", gcpLoginType)
 		}
 	}
 
@@ -174,6 +213,11 @@ func init() {
 	//GCP Backend login
 	viper.SetDefault("project_id", "")
 	viper.SetDefault("google_application_credentials", "")
+	viper.SetDefault("gcp_login_type", vault.GCPLoginTypeIAM)
+	viper.SetDefault("gcp_mount_path", vault.DefaultGCPMountPath)
+	viper.SetDefault("gcp_jwt_expiry", vault.DefaultGCPJWTExpiry)
+	viper.SetDefault("impersonate_service_account", "")
+	viper.SetDefault("impersonate_delegates", []string{})
 
 	viper.AutomaticEnv()
 
@@ -181,7 +225,12 @@ func init() {
 	vaultCmd.Flags().StringVarP(&vaultBackend, "backend", "b", viper.GetString("vault_backend"), "Vault authentication backend [kubernetes, gcp]")
 	vaultCmd.Flags().StringVarP(&kubernetesBackend, "kubernetes-backend", "k", viper.GetString("kubernetes_backend"), "Kubernetes backend authentication path")
 	vaultCmd.Flags().StringVar(&GCPBackendProjectID, "project-id", viper.GetString("project_id"), "GCP Project ID for GCP backend login")
-	vaultCmd.Flags().StringVarP(&credsPath, "google-application-credentials", "a", viper.GetString("google_application_credentials"), "The file path to the GCP service account json file with permission to the secret")
+	vaultCmd.Flags().StringVarP(&credsPath, "google-application-credentials", "a", viper.GetString("google_application_credentials"), "The file path to the GCP service account json file for GCP backend login (default: Application Default Credentials)")
+	vaultCmd.Flags().StringVar(&gcpLoginType, "gcp-login-type", viper.GetString("gcp_login_type"), "GCP backend login type [iam, gce]")
+	vaultCmd.Flags().StringVar(&gcpMountPath, "gcp-mount-path", viper.GetString("gcp_mount_path"), "GCP backend auth method mount path")
+	vaultCmd.Flags().DurationVar(&gcpJWTExpiry, "gcp-jwt-expiry", viper.GetDuration("gcp_jwt_expiry"), "Expiry of the signed JWT for the GCP backend iam login type, up to the role max_jwt_exp")
+	vaultCmd.Flags().StringVar(&vaultImpersonateServiceAccount, "impersonate-service-account", viper.GetString("impersonate_service_account"), "Email of a service account to sign the GCP backend iam login JWT as")
+	vaultCmd.Flags().StringSliceVar(&vaultImpersonateDelegates, "impersonate-delegates", viper.GetStringSlice("impersonate_delegates"), "Comma separated chain of service accounts emails to impersonate before --impersonate-service-account")
 
 	// Role, and Token Path location for kubernetes backend login
 	vaultCmd.Flags().StringVar(&vaultRole, "role", viper.GetString("vault_role"), "Vault role (required)")
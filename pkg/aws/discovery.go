@@ -0,0 +1,150 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	log "github.com/sirupsen/logrus"
+)
+
+// ParseTags parse a list of key=value strings into a tags map
+func ParseTags(tags []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		split := strings.SplitN(tag, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("bad tag filter %q, expected key=value", tag)
+		}
+		parsed[split[0]] = split[1]
+	}
+	return parsed, nil
+}
+
+func isDiscovery(cfg *Config) bool {
+	return cfg.NamePrefix != "" || len(cfg.Tags) > 0
+}
+
+func buildListSecretsInput(cfg *Config) *secretsmanager.ListSecretsInput {
+	var filters []*secretsmanager.Filter
+	if cfg.NamePrefix != "" {
+		filters = append(filters, &secretsmanager.Filter{
+			Key:    aws.String(secretsmanager.FilterNameStringTypeName),
+			Values: aws.StringSlice([]string{cfg.NamePrefix}),
+		})
+	}
+	// the tag-key and tag-value filters are not paired, the exact key=value match
+	// is done on the listed secret tags
+	for key, value := range cfg.Tags {
+		filters = append(filters,
+			&secretsmanager.Filter{Key: aws.String(secretsmanager.FilterNameStringTypeTagKey), Values: aws.StringSlice([]string{key})},
+			&secretsmanager.Filter{Key: aws.String(secretsmanager.FilterNameStringTypeTagValue), Values: aws.StringSlice([]string{value})},
+		)
+	}
+	return &secretsmanager.ListSecretsInput{Filters: filters}
+}
+
+func hasTags(entry *secretsmanager.SecretListEntry, tags map[string]string) bool {
+	secretTags := make(map[string]string, len(entry.Tags))
+	for _, tag := range entry.Tags {
+		secretTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for key, value := range tags {
+		if v, ok := secretTags[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ListSecretNames list the names of every secret matching the name prefix and tags
+func ListSecretNames(ctx context.Context, api secretsmanageriface.SecretsManagerAPI, cfg *Config) ([]string, error) {
+	var names []string
+	input := buildListSecretsInput(cfg)
+	log.Debugf("Listing secrets with name prefix: %q and tags: %v", cfg.NamePrefix, cfg.Tags)
+
+	for {
+		var page *secretsmanager.ListSecretsOutput
+		err := withRetries(ctx, cfg.maxRetries(), func() error {
+			var err error
+			page, err = api.ListSecretsWithContext(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %w", classifyError(ctx, cfg.NamePrefix, err))
+		}
+
+		for _, entry := range page.SecretList {
+			name := aws.StringValue(entry.Name)
+			if !strings.HasPrefix(name, cfg.NamePrefix) || !hasTags(entry, cfg.Tags) {
+				continue
+			}
+			names = append(names, name)
+		}
+
+		if aws.StringValue(page.NextToken) == "" {
+			break
+		}
+		input.NextToken = page.NextToken
+	}
+
+	sort.Strings(names)
+	if len(names) == 0 {
+		log.Warnf("no secrets matched the name prefix %q and tags %v, check your secrets and filters", cfg.NamePrefix, cfg.Tags)
+	} else {
+		log.Debugf("Discovered secrets: %v", names)
+	}
+	return names, nil
+}
+
+// DiscoverSecrets fetch every secret matching the name prefix and tags and merge them into one map,
+// with UseSecretNamesAsKeys the last segment of the secret name is the key and the secret string its value
+func DiscoverSecrets(ctx context.Context, api secretsmanageriface.SecretsManagerAPI, cfg *Config) (map[string]interface{}, error) {
+	names, err := ListSecretNames(ctx, api, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("could not find secrets with name prefix: %q and tags: %v", cfg.NamePrefix, cfg.Tags)
+	}
+
+	if cfg.UseSecretNamesAsKeys {
+		log.Debugf("Using secret names as keys")
+	} else {
+		log.Debugf("Using secret keys and values")
+	}
+
+	merger := merge.NewMerger()
+	for _, name := range names {
+		discovered := *cfg
+		discovered.SecretName = aws.String(name)
+		secretValueInput, err := buildSecretValueInput(&discovered)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.UseSecretNamesAsKeys {
+			secretValueOutput, err := getSecretValue(ctx, api, secretValueInput, cfg.maxRetries())
+			if err != nil {
+				return nil, err
+			}
+			merger.Add(name, map[string]interface{}{path.Base(name): aws.StringValue(secretValueOutput.SecretString)})
+			merger.SetVersion(name, aws.StringValue(secretValueOutput.VersionId))
+			continue
+		}
+
+		data, version, err := getSecretVersionData(ctx, api, secretValueInput, cfg.maxRetries())
+		if err != nil {
+			return nil, err
+		}
+		merger.Add(name, data)
+		merger.SetVersion(name, version)
+	}
+	return merger.Result(cfg.Recorder), nil
+}
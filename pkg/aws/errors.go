@@ -0,0 +1,93 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// Kinds of Secrets Manager failures, use errors.Is to check a returned error against them
+var (
+	ErrSecretNotFound    = errors.New("secret not found")
+	ErrAccessDenied      = errors.New("access denied")
+	ErrDecryptionFailure = errors.New("secret decryption failed")
+	ErrThrottled         = errors.New("request throttled")
+	ErrTimeout           = errors.New("request timed out")
+)
+
+const errCodeAccessDenied = "AccessDeniedException"
+
+// SecretError is a classified Secrets Manager failure with an actionable message
+type SecretError struct {
+	Kind       error
+	SecretName string
+	Hint       string
+	Err        error
+}
+
+func (e *SecretError) Error() string {
+	return fmt.Sprintf("%v for %q: %s: %v", e.Kind, e.SecretName, e.Hint, e.Err)
+}
+
+// Unwrap returns the original AWS error
+func (e *SecretError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the given kind
+func (e *SecretError) Is(target error) bool {
+	return e.Kind == target
+}
+
+// classifyError wraps known Secrets Manager error codes and an expired context into a SecretError,
+// any other error is returned as is
+func classifyError(ctx context.Context, secretName string, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &SecretError{Kind: ErrTimeout, SecretName: secretName, Err: err,
+			Hint: "the request did not complete in time, check connectivity to the endpoint or raise --timeout"}
+	}
+
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return err
+	}
+
+	switch {
+	case aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException:
+		return &SecretError{Kind: ErrSecretNotFound, SecretName: secretName, Err: err,
+			Hint: "check the secret name, region and that the secret or its version stage exists"}
+	case aerr.Code() == errCodeAccessDenied:
+		return &SecretError{Kind: ErrAccessDenied, SecretName: secretName, Err: err,
+			Hint: "the caller (or --role-arn) needs secretsmanager:GetSecretValue on the secret"}
+	case aerr.Code() == secretsmanager.ErrCodeDecryptionFailure:
+		return &SecretError{Kind: ErrDecryptionFailure, SecretName: secretName, Err: err,
+			Hint: "the caller (or --role-arn) needs kms:Decrypt on the KMS key that encrypts the secret"}
+	case request.IsErrorThrottle(err):
+		return &SecretError{Kind: ErrThrottled, SecretName: secretName, Err: err,
+			Hint: "too many requests, retries were exhausted"}
+	}
+	return err
+}
+
+// isRetryable reports whether the error is throttling or a transient network / server error
+func isRetryable(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == request.ErrCodeRequestError {
+		return request.IsErrorRetryable(err)
+	}
+	return false
+}
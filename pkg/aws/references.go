@@ -0,0 +1,34 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// GetSecretStrings gets the current secret string of each secret name or ARN once
+func GetSecretStrings(api secretsmanageriface.SecretsManagerAPI, cfg *Config, names []string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+
+	secrets := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		secretValueInput := &secretsmanager.GetSecretValueInput{
+			SecretId:     aws.String(name),
+			VersionStage: aws.String("AWSCURRENT"),
+		}
+		secretString, err := getSecretString(ctx, api, secretValueInput, cfg.maxRetries())
+		if err != nil {
+			return nil, err
+		}
+		secrets[name] = secretString
+	}
+	return secrets, nil
+}
+
+// ResolveSecretStrings creates a Secrets Manager client and gets the secret string of each secret
+func ResolveSecretStrings(cfg *Config, names []string) (map[string]interface{}, error) {
+	return GetSecretStrings(newSecretManagerClient(cfg), cfg, names)
+}
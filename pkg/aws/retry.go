@@ -0,0 +1,48 @@
+package aws
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxRetries is the number of retries for throttled and transient failures
+	DefaultMaxRetries = 5
+	// DefaultTimeout is the overall timeout for retrieving the secrets
+	DefaultTimeout = 30 * time.Second
+
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// backoff returns an exponential delay with full jitter for the given attempt
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << uint(attempt)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+// withRetries calls fn until it succeeds, fails with a non retryable error,
+// runs out of retries or the context is done
+func withRetries(ctx context.Context, maxRetries int, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryable(err) || attempt >= maxRetries {
+			return err
+		}
+
+		delay := backoff(attempt)
+		log.Debugf("Retryable error from AWS Secrets Manager, retrying in %v (%d/%d): %v", delay, attempt+1, maxRetries, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
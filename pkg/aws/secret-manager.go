@@ -4,12 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -19,12 +23,100 @@ type Config struct {
 	SecretName      *string
 	PreviousVersion string
 	RoleARN         string
+	// EndpointURL overrides the Secrets Manager endpoint (LocalStack, VPC interface endpoint etc.)
+	EndpointURL string
+	// STSEndpoint overrides the STS endpoint used to assume RoleARN
+	STSEndpoint          string
+	UseFIPSEndpoint      bool
+	UseDualStackEndpoint bool
+	// NamePrefix and Tags discover every matching secret instead of a single SecretName
+	NamePrefix           string
+	Tags                 map[string]string
+	UseSecretNamesAsKeys bool
+	// MaxRetries for throttled and transient errors, 0 uses DefaultMaxRetries and a negative value disables retries
+	MaxRetries int
+	// Timeout for retrieving all the secrets, 0 uses DefaultTimeout
+	Timeout time.Duration
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
 }
 
-func newSecretManagerClient(region, roleArn string) *secretsmanager.SecretsManager {
+func (cfg *Config) maxRetries() int {
+	switch {
+	case cfg.MaxRetries < 0:
+		return 0
+	case cfg.MaxRetries == 0:
+		return DefaultMaxRetries
+	}
+	return cfg.MaxRetries
+}
+
+func (cfg *Config) timeout() time.Duration {
+	if cfg.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return cfg.Timeout
+}
+
+// NewEndpointResolver resolves the Secrets Manager and STS endpoints from the config, any other
+// service or an unset override falls back to the SDK default resolver, with the FIPS and dual-stack
+// variants of the partition of the region
+func NewEndpointResolver(cfg *Config) endpoints.ResolverFunc {
+	return func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		switch {
+		case service == secretsmanager.EndpointsID && cfg.EndpointURL != "":
+			log.Debugf("Using Secrets Manager endpoint: %s", cfg.EndpointURL)
+			return customEndpoint(cfg.EndpointURL, service, region), nil
+		case service == sts.EndpointsID && cfg.STSEndpoint != "":
+			log.Debugf("Using STS endpoint: %s", cfg.STSEndpoint)
+			return customEndpoint(cfg.STSEndpoint, service, region), nil
+		}
+		opts = append(opts, func(o *endpoints.Options) {
+			if cfg.UseFIPSEndpoint {
+				o.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+			}
+			if cfg.UseDualStackEndpoint {
+				o.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+			}
+		})
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	}
+}
+
+func customEndpoint(url, service, region string) endpoints.ResolvedEndpoint {
+	return endpoints.ResolvedEndpoint{
+		URL:           url,
+		SigningRegion: region,
+		SigningName:   service,
+		SigningMethod: "v4",
+	}
+}
+
+// fipsEndpoint is the FIPS endpoint state of the SDK config, unset leaves it to the shared config
+func (cfg *Config) fipsEndpoint() endpoints.FIPSEndpointState {
+	if cfg.UseFIPSEndpoint {
+		return endpoints.FIPSEndpointStateEnabled
+	}
+	return endpoints.FIPSEndpointStateUnset
+}
+
+// dualStackEndpoint is the dual-stack endpoint state of the SDK config, unset leaves it to the shared config
+func (cfg *Config) dualStackEndpoint() endpoints.DualStackEndpointState {
+	if cfg.UseDualStackEndpoint {
+		return endpoints.DualStackEndpointStateEnabled
+	}
+	return endpoints.DualStackEndpointStateUnset
+}
+
+func newSecretManagerClient(cfg *Config) *secretsmanager.SecretsManager {
+	region, roleArn := cfg.Region, cfg.RoleARN
 	log.Infof("Using region: %s", region)
+	// the Secrets Manager and STS clients both resolve the FIPS and dual-stack endpoints of the session
 	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region), // Sessions Manager functions require region configuration
+		Region:               aws.String(region), // Sessions Manager functions require region configuration
+		EndpointResolver:     NewEndpointResolver(cfg),
+		UseFIPSEndpoint:      cfg.fipsEndpoint(),
+		UseDualStackEndpoint: cfg.dualStackEndpoint(),
 	}))
 
 	if roleArn != "" {
@@ -33,31 +125,62 @@ func newSecretManagerClient(region, roleArn string) *secretsmanager.SecretsManag
 		sess.Config.Credentials = stscreds.NewCredentials(sess, roleArn)
 	}
 
-	// Create a SecretsManager client with additional configuration
-	return secretsmanager.New(sess, aws.NewConfig().WithRegion(region))
+	// Create a SecretsManager client with additional configuration,
+	// throttled and transient errors are retried by withRetries instead of the SDK
+	return secretsmanager.New(sess, aws.NewConfig().WithRegion(region).WithMaxRetries(0))
 }
 
-// GetSecretData will fetch the secret from secret manager
-func GetSecretData(api secretsmanageriface.SecretsManagerAPI, secretValueInput *secretsmanager.GetSecretValueInput) (map[string]interface{}, error) {
-	var secretData map[string]interface{}
-	ctx := context.Background()
-	secretValueOutput, err := api.GetSecretValueWithContext(ctx, secretValueInput)
+func getSecretValue(ctx context.Context, api secretsmanageriface.SecretsManagerAPI, secretValueInput *secretsmanager.GetSecretValueInput, maxRetries int) (*secretsmanager.GetSecretValueOutput, error) {
+	var secretValueOutput *secretsmanager.GetSecretValueOutput
+	err := withRetries(ctx, maxRetries, func() error {
+		var err error
+		secretValueOutput, err = api.GetSecretValueWithContext(ctx, secretValueInput)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret version: %w", classifyError(ctx, aws.StringValue(secretValueInput.SecretId), err))
+	}
+	return secretValueOutput, nil
+}
 
+func getSecretString(ctx context.Context, api secretsmanageriface.SecretsManagerAPI, secretValueInput *secretsmanager.GetSecretValueInput, maxRetries int) (string, error) {
+	secretValueOutput, err := getSecretValue(ctx, api, secretValueInput, maxRetries)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(secretValueOutput.SecretString), nil
+}
+
+// getSecretVersionData returns the decoded secret JSON data and the version ID of the secret
+func getSecretVersionData(ctx context.Context, api secretsmanageriface.SecretsManagerAPI, secretValueInput *secretsmanager.GetSecretValueInput, maxRetries int) (map[string]interface{}, string, error) {
+	var secretData map[string]interface{}
+	secretValueOutput, err := getSecretValue(ctx, api, secretValueInput, maxRetries)
 	if err != nil {
-		return nil, fmt.Errorf("failed to access secret version: %w", err)
+		return nil, "", err
 	}
 
-	err = json.Unmarshal([]byte(*secretValueOutput.SecretString), &secretData)
+	err = json.Unmarshal([]byte(aws.StringValue(secretValueOutput.SecretString)), &secretData)
 	if err != nil {
-		return nil, fmt.Errorf("bad secret JSON data, can not decode secret JSON data: %w", err)
+		return nil, "", fmt.Errorf("bad secret JSON data, can not decode secret JSON data: %w", err)
 	}
-	return secretData, nil
+	return secretData, aws.StringValue(secretValueOutput.VersionId), nil
+}
+
+func getSecretData(ctx context.Context, api secretsmanageriface.SecretsManagerAPI, secretValueInput *secretsmanager.GetSecretValueInput, maxRetries int) (map[string]interface{}, error) {
+	secretData, _, err := getSecretVersionData(ctx, api, secretValueInput, maxRetries)
+	return secretData, err
+}
+
+// GetSecretData will fetch the secret from secret manager
+func GetSecretData(api secretsmanageriface.SecretsManagerAPI, secretValueInput *secretsmanager.GetSecretValueInput) (map[string]interface{}, error) {
+	return getSecretData(context.Background(), api, secretValueInput, DefaultMaxRetries)
 }
 
 func buildSecretValueInput(cfg *Config) (*secretsmanager.GetSecretValueInput, error) {
 	secretName := cfg.SecretName
 	if aws.StringValue(secretName) == "" {
-		return nil, fmt.Errorf("error: missing SECRET_NAME environment variable")
+		return nil, fmt.Errorf("error: missing SECRET_NAME environment variable, or --name-prefix / --tag to discover secrets")
 	}
 	versionStage := aws.String("AWSCURRENT")
 	if cfg.PreviousVersion != "" {
@@ -73,15 +196,26 @@ func buildSecretValueInput(cfg *Config) (*secretsmanager.GetSecretValueInput, er
 // RetrieveSecret from AWS secrets manager
 func RetrieveSecret(cfg *Config) (map[string]interface{}, error) {
 	log.Info("Using AWS Secret Manager")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+
+	if isDiscovery(cfg) {
+		client := newSecretManagerClient(cfg)
+		return DiscoverSecrets(ctx, client, cfg)
+	}
+
 	secretValueInput, err := buildSecretValueInput(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	client := newSecretManagerClient(cfg.Region, cfg.RoleARN)
-	secretData, err := GetSecretData(client, secretValueInput)
+	client := newSecretManagerClient(cfg)
+	secretData, version, err := getSecretVersionData(ctx, client, secretValueInput, cfg.maxRetries())
 	if err != nil {
 		return nil, err
 	}
-	return secretData, nil
+	merger := merge.NewMerger()
+	merger.Add(aws.StringValue(cfg.SecretName), secretData)
+	merger.SetVersion(aws.StringValue(cfg.SecretName), version)
+	return merger.Result(cfg.Recorder), nil
 }
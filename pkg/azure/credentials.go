@@ -0,0 +1,183 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Authentication methods
+const (
+	AuthClientSecret     = "client-secret"
+	AuthManagedIdentity  = "managed-identity"
+	AuthWorkloadIdentity = "workload-identity"
+)
+
+const (
+	// DefaultAuthorityHost is the Azure AD endpoint of the public cloud
+	DefaultAuthorityHost = "https://login.microsoftonline.com/"
+	// DefaultManagedIdentityEndpoint is the token endpoint of the instance metadata service
+	DefaultManagedIdentityEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// AuthMethod returns the configured authentication method, or the one the credentials settings imply:
+// client secret, then workload identity with a federated token file, then managed identity
+func (cfg *Config) AuthMethod() string {
+	switch {
+	case cfg.Auth != "":
+		return cfg.Auth
+	case cfg.ClientSecret != "":
+		return AuthClientSecret
+	case cfg.FederatedTokenFile != "":
+		return AuthWorkloadIdentity
+	default:
+		return AuthManagedIdentity
+	}
+}
+
+func (cfg *Config) tokenURL() string {
+	authorityHost := cfg.AuthorityHost
+	if authorityHost == "" {
+		authorityHost = DefaultAuthorityHost
+	}
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), cfg.TenantID)
+}
+
+// NewTokenSource returns a token source for the Key Vault scope with the configured authentication method
+func NewTokenSource(ctx context.Context, cfg *Config, scope string) (oauth2.TokenSource, error) {
+	method := cfg.AuthMethod()
+	log.Debugf("Using Azure %s authentication", method)
+
+	switch method {
+	case AuthClientSecret:
+		if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, fmt.Errorf("client secret authentication needs a tenant ID, client ID and client secret")
+		}
+		credentials := &clientcredentials.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			TokenURL:     cfg.tokenURL(),
+			Scopes:       []string{scope},
+			AuthStyle:    oauth2.AuthStyleInParams,
+		}
+		return credentials.TokenSource(ctx), nil
+	case AuthWorkloadIdentity:
+		if cfg.TenantID == "" || cfg.ClientID == "" || cfg.FederatedTokenFile == "" {
+			return nil, fmt.Errorf("workload identity authentication needs a tenant ID, client ID and federated token file")
+		}
+		tokenSource := &workloadIdentityTokenSource{
+			ctx:       ctx,
+			tokenFile: cfg.FederatedTokenFile,
+			credentials: clientcredentials.Config{
+				ClientID:  cfg.ClientID,
+				TokenURL:  cfg.tokenURL(),
+				Scopes:    []string{scope},
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		}
+		return oauth2.ReuseTokenSource(nil, tokenSource), nil
+	case AuthManagedIdentity:
+		endpoint := cfg.ManagedIdentityEndpoint
+		if endpoint == "" {
+			endpoint = DefaultManagedIdentityEndpoint
+		}
+		tokenSource := &managedIdentityTokenSource{
+			ctx:      ctx,
+			endpoint: endpoint,
+			resource: strings.TrimSuffix(scope, "/.default"),
+			clientID: cfg.ClientID,
+		}
+		return oauth2.ReuseTokenSource(nil, tokenSource), nil
+	}
+	return nil, fmt.Errorf("unknown Azure authentication method %q, expected %s, %s or %s", method, AuthClientSecret, AuthManagedIdentity, AuthWorkloadIdentity)
+}
+
+// workloadIdentityTokenSource exchanges the federated token for an Azure AD token as a client
+// assertion, the token file is read on every token request so rotated projected tokens are picked up
+type workloadIdentityTokenSource struct {
+	ctx         context.Context
+	tokenFile   string
+	credentials clientcredentials.Config
+}
+
+// Token gets an access token with the current federated token
+func (w *workloadIdentityTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := ioutil.ReadFile(w.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the federated token file %v", err)
+	}
+	credentials := w.credentials
+	credentials.EndpointParams = url.Values{
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	return credentials.Token(w.ctx)
+}
+
+// managedIdentityTokenSource gets tokens from the instance metadata service,
+// clientID selects a user assigned identity
+type managedIdentityTokenSource struct {
+	ctx      context.Context
+	endpoint string
+	resource string
+	clientID string
+}
+
+type managedIdentityToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresOn   string `json:"expires_on"`
+	TokenType   string `json:"token_type"`
+}
+
+// Token gets an access token for the resource from the instance metadata service
+func (m *managedIdentityTokenSource) Token() (*oauth2.Token, error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", m.resource)
+	if m.clientID != "" {
+		query.Set("client_id", m.clientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, m.endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	log.Debugf("Getting managed identity token for %s", m.resource)
+	resp, err := http.DefaultClient.Do(req.WithContext(m.ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error getting managed identity token, managed identity only works on Azure hosts %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading managed identity token %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting managed identity token, status %d: %s", resp.StatusCode, body)
+	}
+
+	var token managedIdentityToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("error decoding managed identity token %v", err)
+	}
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing managed identity token expiry %v", err)
+	}
+	return &oauth2.Token{AccessToken: token.AccessToken, TokenType: "Bearer", Expiry: time.Unix(expiresOn, 0)}, nil
+}
@@ -0,0 +1,342 @@
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// APIVersion of the Key Vault REST API
+const APIVersion = "7.4"
+
+// Config - configuration for Azure Key Vault
+type Config struct {
+	// VaultURL is the Key Vault URL like https://<vault-name>.vault.azure.net
+	VaultURL      string
+	SecretName    string
+	SecretVersion string
+	// SecretsConfigList holds the secrets and certificates, fetched concurrently with SecretName
+	SecretsConfigList []SecretConfig
+
+	// Auth is one of AuthClientSecret, AuthManagedIdentity or AuthWorkloadIdentity, empty to pick it from the settings
+	Auth                    string
+	TenantID                string
+	ClientID                string
+	ClientSecret            string
+	FederatedTokenFile      string
+	AuthorityHost           string
+	ManagedIdentityEndpoint string
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// Secret payload formats and object kinds
+const (
+	FormatJSON = "json"
+	FormatRaw  = "raw"
+
+	KindSecret      = "secret"
+	KindCertificate = "certificate"
+)
+
+// SecretConfig holds a single secret or certificate config
+type SecretConfig struct {
+	Name    string
+	Version string
+	// Kind is KindSecret or KindCertificate, a certificate is exported as its PEM public certificate
+	Kind string
+	// Format is FormatRaw (default) to export the value as is or FormatJSON to expand its keys
+	Format string
+	// EnvName is the variable name of a raw value, defaults to the normalized secret name
+	EnvName string
+}
+
+// SecretConfigJSON JSON struct for secret config
+type SecretConfigJSON struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+	Format  string `json:"format"`
+	Env     string `json:"env"`
+}
+
+// ConfigureSecrets decode the JSON secret configs into SecretConfig list
+func ConfigureSecrets(secretConfigs []string) ([]SecretConfig, error) {
+	/*
+		secret config is a JSON string like
+		'{"name": "db-password", "version": "<version id>", "env": "DB_PASSWORD"}'
+		'{"name": "app-config", "format": "json"}'
+		'{"name": "tls-cert", "kind": "certificate"}'
+	*/
+	var secretsConfigList []SecretConfig
+
+	for _, secretConfigJSONString := range secretConfigs {
+		var secretConfigData SecretConfigJSON
+		err := json.Unmarshal([]byte(secretConfigJSONString), &secretConfigData)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode JSON from string %s - %+v", secretConfigJSONString, err)
+		}
+		if secretConfigData.Name == "" {
+			return nil, fmt.Errorf("secret config %s is missing the secret name", secretConfigJSONString)
+		}
+
+		kind := strings.ToLower(secretConfigData.Kind)
+		switch kind {
+		case "":
+			kind = KindSecret
+		case KindSecret, KindCertificate:
+		default:
+			return nil, fmt.Errorf("unknown kind %q in secret config %s, expected secret or certificate", secretConfigData.Kind, secretConfigJSONString)
+		}
+
+		format := strings.ToLower(secretConfigData.Format)
+		switch {
+		case format == "":
+			format = FormatRaw
+		case format != FormatJSON && format != FormatRaw:
+			return nil, fmt.Errorf("unknown format %q in secret config %s, expected json or raw", secretConfigData.Format, secretConfigJSONString)
+		case format == FormatJSON && kind == KindCertificate:
+			return nil, fmt.Errorf("certificates can not use the json format in secret config %s", secretConfigJSONString)
+		}
+
+		secretsConfigList = append(secretsConfigList, SecretConfig{
+			Name:    secretConfigData.Name,
+			Version: secretConfigData.Version,
+			Kind:    kind,
+			Format:  format,
+			EnvName: secretConfigData.Env,
+		})
+	}
+	return secretsConfigList, nil
+}
+
+// CertificateConfigs returns a SecretConfig for each certificate name
+func CertificateConfigs(names []string) []SecretConfig {
+	var secretsConfigList []SecretConfig
+	for _, name := range names {
+		secretsConfigList = append(secretsConfigList, SecretConfig{Name: name, Kind: KindCertificate, Format: FormatRaw})
+	}
+	return secretsConfigList
+}
+
+var invalidEnvNameChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// NormalizeName turns a Key Vault object name into an env var name, Key Vault names only allow
+// alphanumerics and dashes so db-password becomes DB_PASSWORD
+func NormalizeName(name string) string {
+	return invalidEnvNameChars.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+// VaultURL returns the URL of the named vault in the public cloud
+func VaultURL(vaultName string) string {
+	return fmt.Sprintf("https://%s.vault.azure.net", vaultName)
+}
+
+// Scope returns the token scope of the vault, derived from its DNS suffix for the sovereign clouds
+func Scope(vaultURL string) string {
+	u, err := url.Parse(vaultURL)
+	if err == nil {
+		if i := strings.Index(u.Hostname(), ".vault."); i > 0 {
+			return fmt.Sprintf("https://%s/.default", u.Hostname()[i+1:])
+		}
+	}
+	return "https://vault.azure.net/.default"
+}
+
+// Kinds of Key Vault failures, use errors.Is to check a returned error against them
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrAccessDenied   = errors.New("access denied")
+)
+
+// KeyVaultError is an error returned by the Key Vault REST API
+type KeyVaultError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Name       string
+}
+
+func (e *KeyVaultError) Error() string {
+	return fmt.Sprintf("Key Vault error for %q, status %d %s: %s", e.Name, e.StatusCode, e.Code, e.Message)
+}
+
+// Is reports whether the error is of the given kind
+func (e *KeyVaultError) Is(target error) bool {
+	switch target {
+	case ErrSecretNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAccessDenied:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// Client reads secrets and certificates from a Key Vault
+type Client struct {
+	VaultURL   string
+	HTTPClient *http.Client
+}
+
+// NewClient create a new Key Vault client authenticated with the configured credentials
+func NewClient(ctx context.Context, cfg *Config) (*Client, error) {
+	log.Info("Creating new Azure Key Vault client")
+	if cfg.VaultURL == "" {
+		return nil, errors.New("Key Vault URL is missing")
+	}
+	tokenSource, err := NewTokenSource(ctx, cfg, Scope(cfg.VaultURL))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		VaultURL:   strings.TrimSuffix(cfg.VaultURL, "/"),
+		HTTPClient: oauth2.NewClient(ctx, tokenSource),
+	}, nil
+}
+
+type secretBundle struct {
+	Value       string `json:"value"`
+	ContentType string `json:"contentType"`
+	ID          string `json:"id"`
+}
+
+type certificateBundle struct {
+	Cer string `json:"cer"`
+	ID  string `json:"id"`
+}
+
+type keyVaultErrorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *Client) get(ctx context.Context, collection, name, version string, v interface{}) error {
+	objectURL := fmt.Sprintf("%s/%s/%s", c.VaultURL, collection, url.PathEscape(name))
+	if version != "" && version != "latest" {
+		objectURL = fmt.Sprintf("%s/%s", objectURL, url.PathEscape(version))
+	}
+	req, err := http.NewRequest(http.MethodGet, objectURL+"?api-version="+APIVersion, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Getting %s", objectURL)
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s: %v", collection, name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s %s: %v", collection, name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errorBody keyVaultErrorBody
+		_ = json.Unmarshal(body, &errorBody)
+		return &KeyVaultError{StatusCode: resp.StatusCode, Code: errorBody.Error.Code, Message: errorBody.Error.Message, Name: name}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("bad response for %s %s, can not decode JSON %v", collection, name, err)
+	}
+	return nil
+}
+
+// GetSecret returns the value of the secret version, the latest version when version is empty
+func (c *Client) GetSecret(ctx context.Context, name, version string) (string, error) {
+	var bundle secretBundle
+	if err := c.get(ctx, "secrets", name, version, &bundle); err != nil {
+		return "", err
+	}
+	return bundle.Value, nil
+}
+
+// GetCertificate returns the PEM public certificate of the certificate version
+func (c *Client) GetCertificate(ctx context.Context, name, version string) (string, error) {
+	var bundle certificateBundle
+	if err := c.get(ctx, "certificates", name, version, &bundle); err != nil {
+		return "", err
+	}
+	der, err := base64.StdEncoding.DecodeString(bundle.Cer)
+	if err != nil {
+		return "", fmt.Errorf("bad certificate %s, can not decode base64 %v", name, err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+func retrieveSecretConfig(ctx context.Context, client *Client, secretConfig SecretConfig) (map[string]interface{}, error) {
+	var (
+		value string
+		err   error
+	)
+	if secretConfig.Kind == KindCertificate {
+		value, err = client.GetCertificate(ctx, secretConfig.Name, secretConfig.Version)
+	} else {
+		value, err = client.GetSecret(ctx, secretConfig.Name, secretConfig.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if secretConfig.Format == FormatJSON {
+		var secretData map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &secretData); err != nil {
+			return nil, fmt.Errorf("%s: bad secret JSON data, can not decode secret JSON data %v, use the raw format for plain text secrets", secretConfig.Name, err)
+		}
+		return secretData, nil
+	}
+
+	name := secretConfig.EnvName
+	if name == "" {
+		name = NormalizeName(secretConfig.Name)
+	}
+	return map[string]interface{}{name: value}, nil
+}
+
+// RetrieveSecret get the secrets and certificates from Key Vault
+func RetrieveSecret(client *Client, cfg *Config) (map[string]interface{}, error) {
+	ctx := context.Background()
+
+	secretsConfigList := cfg.SecretsConfigList
+	if cfg.SecretName != "" {
+		secretsConfigList = append([]SecretConfig{{
+			Name:    cfg.SecretName,
+			Version: cfg.SecretVersion,
+			Kind:    KindSecret,
+			Format:  FormatRaw,
+		}}, secretsConfigList...)
+	}
+
+	logger := log.WithFields(log.Fields{
+		"vault_url": client.VaultURL,
+		"auth":      cfg.AuthMethod(),
+		"secrets":   len(secretsConfigList),
+	})
+	logger.Info("Getting secrets from Azure Key Vault")
+
+	names := make([]string, len(secretsConfigList))
+	for i, secretConfig := range secretsConfigList {
+		names[i] = secretConfig.Name
+	}
+	merger, err := merge.Fetch(names, func(i int) (map[string]interface{}, error) {
+		return retrieveSecretConfig(ctx, client, secretsConfigList[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merger.Result(cfg.Recorder), nil
+}
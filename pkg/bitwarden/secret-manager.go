@@ -0,0 +1,210 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHost is the address of a local `bw serve`
+const DefaultHost = "http://localhost:8087"
+
+const requestTimeout = 30 * time.Second
+
+// Config - configuration for the Bitwarden CLI Vault Management API, served by `bw serve`
+// logged in to Bitwarden or to a Vaultwarden server
+type Config struct {
+	Host string
+	// Items are item names or IDs, later items win
+	Items []string
+	// Sync pulls the latest vault data from the server before reading the items
+	Sync bool
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// ErrItemNotFound is returned when no item has the name or ID
+var ErrItemNotFound = errors.New("item not found")
+
+var itemIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var invalidEnvNameChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// EnvName turns a field name into an env var name, so "db password" becomes DB_PASSWORD
+func EnvName(name string) string {
+	return invalidEnvNameChars.ReplaceAllString(strings.ToUpper(strings.TrimSpace(name)), "_")
+}
+
+// Field is a custom field of an item
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  int    `json:"type"`
+}
+
+// Item is a vault item, the login is set for login items
+type Item struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Login *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"login"`
+	Fields []Field `json:"fields"`
+}
+
+type response struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type listData struct {
+	Data []Item `json:"data"`
+}
+
+// Client reads items from the Vault Management API
+type Client struct {
+	Host       string
+	HTTPClient *http.Client
+}
+
+// NewClient create a new Vault Management API client
+func NewClient(cfg *Config) (*Client, error) {
+	log.Info("Creating new Bitwarden client")
+	host := cfg.Host
+	if host == "" {
+		host = DefaultHost
+	}
+	return &Client{
+		Host:       strings.TrimSuffix(host, "/"),
+		HTTPClient: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func (c *Client) do(method, apiPath string, query url.Values, v interface{}) error {
+	apiURL := c.Host + apiPath
+	if len(query) > 0 {
+		apiURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var r response
+	if err := json.Unmarshal(body, &r); err != nil {
+		return fmt.Errorf("status %d, can not decode JSON %v", resp.StatusCode, err)
+	}
+	if !r.Success {
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrItemNotFound
+		}
+		return fmt.Errorf("status %d: %s, is the vault unlocked?", resp.StatusCode, r.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Data, v)
+}
+
+// Sync pulls the latest vault data from the server
+func (c *Client) Sync() error {
+	log.Debug("Syncing the Bitwarden vault")
+	if err := c.do(http.MethodPost, "/sync", nil, nil); err != nil {
+		return fmt.Errorf("failed to sync the vault: %v", err)
+	}
+	return nil
+}
+
+// GetItem returns the item with the ID, or the only item with the exact name
+func (c *Client) GetItem(nameOrID string) (*Item, error) {
+	log.Debugf("Getting Bitwarden item %s", nameOrID)
+	if itemIDPattern.MatchString(nameOrID) {
+		var item Item
+		if err := c.do(http.MethodGet, "/object/item/"+nameOrID, nil, &item); err != nil {
+			return nil, fmt.Errorf("failed to get item %s: %w", nameOrID, err)
+		}
+		return &item, nil
+	}
+
+	// search matches substrings of the names, usernames and URIs
+	var list listData
+	if err := c.do(http.MethodGet, "/list/object/items", url.Values{"search": {nameOrID}}, &list); err != nil {
+		return nil, fmt.Errorf("failed to find item %s: %w", nameOrID, err)
+	}
+	var found []Item
+	for _, item := range list.Data {
+		if item.Name == nameOrID {
+			found = append(found, item)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrItemNotFound, nameOrID)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("%d items are named %s, reference the item by ID", len(found), nameOrID)
+}
+
+// RetrieveSecret get the items and map them to env vars, the login is exported as USERNAME and PASSWORD
+// and the custom fields under their names, later items win
+func RetrieveSecret(client *Client, cfg *Config) (map[string]interface{}, error) {
+	logger := log.WithFields(log.Fields{
+		"host":  client.Host,
+		"items": cfg.Items,
+	})
+	logger.Info("Getting secrets from Bitwarden")
+
+	if cfg.Sync {
+		if err := client.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	merger := merge.NewMerger()
+	for _, nameOrID := range cfg.Items {
+		item, err := client.GetItem(nameOrID)
+		if err != nil {
+			return nil, err
+		}
+		itemData := make(map[string]interface{})
+		if item.Login != nil {
+			if item.Login.Username != "" {
+				itemData["USERNAME"] = item.Login.Username
+			}
+			if item.Login.Password != "" {
+				itemData["PASSWORD"] = item.Login.Password
+			}
+		}
+		for _, field := range item.Fields {
+			if field.Name == "" || field.Value == "" {
+				continue
+			}
+			itemData[EnvName(field.Name)] = field.Value
+		}
+		merger.Add(nameOrID, itemData)
+	}
+	return merger.Result(cfg.Recorder), nil
+}
@@ -0,0 +1,160 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/kv"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultAddress is the address of the local Consul agent
+const DefaultAddress = "http://127.0.0.1:8500"
+
+const requestTimeout = 30 * time.Second
+
+// Config - configuration for Consul KV
+type Config struct {
+	// Address of the Consul agent or server, like https://consul.service.consul:8501
+	Address    string
+	Token      string
+	Datacenter string
+	// Keys are keys or prefixes ending with a "/", later keys win
+	Keys []string
+	TLS  tlsconfig.Config
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// ErrKeyNotFound is returned when a key or prefix does not exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// KVPair is a Consul key and its value
+type KVPair struct {
+	Key   string `json:"Key"`
+	Value []byte `json:"Value"`
+}
+
+// Client reads keys from the Consul KV HTTP API
+type Client struct {
+	Address    string
+	Token      string
+	Datacenter string
+	HTTPClient *http.Client
+}
+
+// NewClient create a new Consul KV client with the ACL token and TLS settings
+func NewClient(cfg *Config) (*Client, error) {
+	log.Info("Creating new Consul client")
+	address := cfg.Address
+	if address == "" {
+		address = DefaultAddress
+	}
+	// CONSUL_HTTP_ADDR can be host:port without a scheme
+	if !strings.Contains(address, "://") {
+		scheme := "http"
+		if cfg.TLS.IsSet() {
+			scheme = "https"
+		}
+		address = scheme + "://" + address
+	}
+
+	tlsConfig, err := tlsconfig.New(&cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Address:    strings.TrimSuffix(address, "/"),
+		Token:      cfg.Token,
+		Datacenter: cfg.Datacenter,
+		HTTPClient: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Get returns the key value pairs of a key, or of every key under a prefix ending with a "/"
+func (c *Client) Get(key string) ([]KVPair, error) {
+	query := url.Values{}
+	if strings.HasSuffix(key, "/") {
+		query.Set("recurse", "true")
+	}
+	if c.Datacenter != "" {
+		query.Set("dc", c.Datacenter)
+	}
+	kvURL := fmt.Sprintf("%s/v1/kv/%s", c.Address, strings.TrimPrefix(key, "/"))
+	if len(query) > 0 {
+		kvURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, kvURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	log.Debugf("Getting Consul key %s", key)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Consul key %s: %v", key, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Consul key %s: %v", key, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("failed to get Consul key %s, the ACL token needs key read access: %s", key, strings.TrimSpace(string(body)))
+	default:
+		return nil, fmt.Errorf("failed to get Consul key %s, status %d: %s", key, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	// the values are base64 encoded, decoded into []byte by encoding/json
+	var pairs []KVPair
+	if err := json.Unmarshal(body, &pairs); err != nil {
+		return nil, fmt.Errorf("bad response for Consul key %s, can not decode JSON %v", key, err)
+	}
+	return pairs, nil
+}
+
+// RetrieveSecret get the keys and prefixes from Consul KV and merge them in order
+func RetrieveSecret(client *Client, cfg *Config) (map[string]interface{}, error) {
+	logger := log.WithFields(log.Fields{
+		"address":    client.Address,
+		"datacenter": cfg.Datacenter,
+		"keys":       cfg.Keys,
+	})
+	logger.Info("Getting secrets from Consul KV")
+
+	merger := merge.NewMerger()
+	for _, key := range cfg.Keys {
+		pairs, err := client.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			// folders under a prefix have no value
+			if strings.HasSuffix(pair.Key, "/") {
+				continue
+			}
+			merger.Add(pair.Key, kv.DecodeValue(pair.Key, pair.Value))
+		}
+	}
+	return merger.Result(cfg.Recorder), nil
+}
@@ -0,0 +1,212 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/kv"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultEndpoint is the client URL of a local etcd member
+const DefaultEndpoint = "http://127.0.0.1:2379"
+
+const requestTimeout = 30 * time.Second
+
+// Config - configuration for etcd
+type Config struct {
+	// Endpoints are the client URLs of the etcd members, tried in order
+	Endpoints []string
+	Username  string
+	Password  string
+	// Keys are keys or prefixes ending with a "/", later keys win
+	Keys []string
+	TLS  tlsconfig.Config
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// ErrKeyNotFound is returned when a key or prefix does not exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// Client reads keys through the etcd v3 JSON gRPC gateway
+type Client struct {
+	Endpoints  []string
+	token      string
+	HTTPClient *http.Client
+}
+
+type rangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type rangeResponse struct {
+	Kvs []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+}
+
+type authenticateRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type authenticateResponse struct {
+	Token string `json:"token"`
+}
+
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// NewClient create a new etcd client, authenticating with the username and password when set
+func NewClient(cfg *Config) (*Client, error) {
+	log.Info("Creating new etcd client")
+	endpoints := cfg.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{DefaultEndpoint}
+	}
+	var trimmed []string
+	for _, endpoint := range endpoints {
+		trimmed = append(trimmed, strings.TrimSuffix(endpoint, "/"))
+	}
+
+	tlsConfig, err := tlsconfig.New(&cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		Endpoints: trimmed,
+		HTTPClient: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}
+
+	if cfg.Username != "" {
+		var resp authenticateResponse
+		err := client.post("/v3/auth/authenticate", &authenticateRequest{Name: cfg.Username, Password: cfg.Password}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate to etcd as %s: %v", cfg.Username, err)
+		}
+		client.token = resp.Token
+	}
+	return client, nil
+}
+
+// post sends the request to the first endpoint that answers
+func (c *Client) post(apiPath string, body, v interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, endpoint := range c.Endpoints {
+		req, err := http.NewRequest(http.MethodPost, endpoint+apiPath, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.token != "" {
+			req.Header.Set("Authorization", c.token)
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			log.Debugf("etcd endpoint %s failed: %v", endpoint, err)
+			lastErr = err
+			continue
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			var errResp errorResponse
+			_ = json.Unmarshal(respBody, &errResp)
+			if errResp.Message == "" {
+				errResp.Message = strings.TrimSpace(string(respBody))
+			}
+			return fmt.Errorf("status %d: %s", resp.StatusCode, errResp.Message)
+		}
+		return json.Unmarshal(respBody, v)
+	}
+	return fmt.Errorf("no etcd endpoint is reachable: %v", lastErr)
+}
+
+// prefixRangeEnd returns the range end covering every key with the prefix
+func prefixRangeEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// the prefix is all 0xff, range to the end of the keyspace
+	return []byte{0}
+}
+
+// KeyValue is an etcd key and its value
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// Get returns the key value pairs of a key, or of every key under a prefix ending with a "/", sorted by key
+func (c *Client) Get(key string) ([]KeyValue, error) {
+	req := &rangeRequest{Key: []byte(key)}
+	if strings.HasSuffix(key, "/") {
+		req.RangeEnd = prefixRangeEnd(req.Key)
+	}
+
+	log.Debugf("Getting etcd key %s", key)
+	var resp rangeResponse
+	if err := c.post("/v3/kv/range", req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get etcd key %s: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+
+	var pairs []KeyValue
+	for _, kv := range resp.Kvs {
+		pairs = append(pairs, KeyValue{Key: string(kv.Key), Value: kv.Value})
+	}
+	return pairs, nil
+}
+
+// RetrieveSecret get the keys and prefixes from etcd and merge them in order
+func RetrieveSecret(client *Client, cfg *Config) (map[string]interface{}, error) {
+	logger := log.WithFields(log.Fields{
+		"endpoints": client.Endpoints,
+		"keys":      cfg.Keys,
+	})
+	logger.Info("Getting secrets from etcd")
+
+	merger := merge.NewMerger()
+	for _, key := range cfg.Keys {
+		pairs, err := client.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			merger.Add(pair.Key, kv.DecodeValue(pair.Key, pair.Value))
+		}
+	}
+	return merger.Result(cfg.Recorder), nil
+}
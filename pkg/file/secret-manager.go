@@ -0,0 +1,166 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	log "github.com/sirupsen/logrus"
+	"github.com/subosito/gotenv"
+	"gopkg.in/yaml.v2"
+)
+
+// File formats
+const (
+	FormatDotenv = "dotenv"
+	FormatJSON   = "json"
+	FormatYAML   = "yaml"
+)
+
+// SOPS decryption modes
+const (
+	SOPSAuto   = "auto"
+	SOPSAlways = "always"
+	SOPSNever  = "never"
+)
+
+// Config - configuration for local secret files
+type Config struct {
+	// Paths of the files, later files win
+	Paths []string
+	// Format of the files, empty to pick it from each file extension
+	Format string
+	// SOPS is SOPSAuto (default) to decrypt the files with SOPS metadata, SOPSAlways or SOPSNever
+	SOPS string
+	// SOPSBinary is the sops executable, defaults to DefaultSOPSBinary
+	SOPSBinary string
+	// AgeKeyFile is the age identities file for SOPS, PGP keys are read from the gpg keyring
+	AgeKeyFile string
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// FormatFromPath returns the file format from its extension, .env and extension-less files are dotenv
+func FormatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".env", "":
+		return FormatDotenv, nil
+	}
+	if strings.HasPrefix(filepath.Base(path), ".env") {
+		return FormatDotenv, nil
+	}
+	return "", fmt.Errorf("unknown format of file %s, pass it with --format [dotenv, json, yaml]", path)
+}
+
+// Parse decode the file data in the given format into a secrets map
+func Parse(data []byte, format string) (map[string]interface{}, error) {
+	secretData := make(map[string]interface{})
+	switch format {
+	case FormatDotenv:
+		env, err := gotenv.StrictParse(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("bad dotenv data %v", err)
+		}
+		for key, value := range env {
+			secretData[key] = value
+		}
+	case FormatJSON:
+		if err := json.Unmarshal(data, &secretData); err != nil {
+			return nil, fmt.Errorf("bad JSON data, can not decode JSON %v", err)
+		}
+	case FormatYAML:
+		var yamlData map[string]interface{}
+		if err := yaml.Unmarshal(data, &yamlData); err != nil {
+			return nil, fmt.Errorf("bad YAML data, can not decode YAML %v", err)
+		}
+		for key, value := range yamlData {
+			secretData[key] = stringKeys(value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected dotenv, json or yaml", format)
+	}
+	return secretData, nil
+}
+
+// stringKeys converts the nested YAML maps into JSON-like maps with string keys
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			converted[fmt.Sprintf("%v", key)] = stringKeys(nested)
+		}
+		return converted
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = stringKeys(nested)
+		}
+	}
+	return value
+}
+
+func readFile(cfg *Config, path string) (map[string]interface{}, error) {
+	format := cfg.Format
+	if format == "" {
+		var err error
+		format, err = FormatFromPath(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file %v", err)
+	}
+
+	sopsMode := cfg.SOPS
+	if sopsMode == "" {
+		sopsMode = SOPSAuto
+	}
+	switch {
+	case sopsMode == SOPSAlways, sopsMode == SOPSAuto && IsSOPSEncrypted(data, format):
+		log.Debugf("Decrypting %s with SOPS", path)
+		data, err = Decrypt(cfg, path, format)
+		if err != nil {
+			return nil, err
+		}
+	case sopsMode != SOPSAuto && sopsMode != SOPSNever:
+		return nil, fmt.Errorf("unknown SOPS mode %q, expected auto, always or never", sopsMode)
+	}
+
+	secretData, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// the SOPS metadata is not a secret
+	delete(secretData, "sops")
+	return secretData, nil
+}
+
+// RetrieveSecret read the secret files and merge their keys in order
+func RetrieveSecret(cfg *Config) (map[string]interface{}, error) {
+	logger := log.WithFields(log.Fields{
+		"paths": cfg.Paths,
+		"sops":  cfg.SOPS,
+	})
+	logger.Info("Reading secrets from files")
+
+	merger := merge.NewMerger()
+	for _, path := range cfg.Paths {
+		fileData, err := readFile(cfg, path)
+		if err != nil {
+			return nil, err
+		}
+		merger.Add(path, fileData)
+	}
+	return merger.Result(cfg.Recorder), nil
+}
@@ -0,0 +1,56 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSOPSBinary is the sops executable looked up in the PATH
+const DefaultSOPSBinary = "sops"
+
+// sopsMetadata matches the metadata SOPS adds to the encrypted files
+var sopsMetadata = map[string]*regexp.Regexp{
+	FormatDotenv: regexp.MustCompile(`(?m)^sops_version=`),
+	FormatJSON:   regexp.MustCompile(`"sops"\s*:\s*\{`),
+	FormatYAML:   regexp.MustCompile(`(?m)^sops:\s*$`),
+}
+
+// IsSOPSEncrypted reports whether the file data holds SOPS metadata
+func IsSOPSEncrypted(data []byte, format string) bool {
+	re, ok := sopsMetadata[format]
+	return ok && re.Match(data)
+}
+
+// Decrypt runs sops to decrypt the file, age keys are read from the AgeKeyFile (or SOPS_AGE_KEY_FILE)
+// and PGP keys from the gpg keyring
+func Decrypt(cfg *Config, path, format string) ([]byte, error) {
+	binary := cfg.SOPSBinary
+	if binary == "" {
+		binary = DefaultSOPSBinary
+	}
+	sopsPath, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("sops is needed to decrypt %s, install it or pass its path with --sops-binary %v", path, err)
+	}
+
+	cmd := exec.Command(sopsPath, "--decrypt", "--input-type", format, "--output-type", format, path)
+	cmd.Env = os.Environ()
+	if cfg.AgeKeyFile != "" {
+		cmd.Env = append(cmd.Env, "SOPS_AGE_KEY_FILE="+cfg.AgeKeyFile)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	log.Debugf("Running %s", strings.Join(cmd.Args, " "))
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s with sops %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return data, nil
+}
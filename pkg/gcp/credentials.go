@@ -0,0 +1,142 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// CloudPlatformScope is the OAuth scope of the GCP APIs
+const CloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// FindCredentials returns the Application Default Credentials, in order: the GOOGLE_APPLICATION_CREDENTIALS
+// file, the gcloud application-default user credentials or the metadata server (GKE Workload Identity, GCE)
+func FindCredentials(ctx context.Context) (*google.Credentials, error) {
+	creds, err := google.FindDefaultCredentials(ctx, CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("error finding application default credentials, use gcloud auth application-default login, a metadata server or --google-application-credentials %v", err)
+	}
+	return creds, nil
+}
+
+// CredentialsEmail returns the email of the credentials identity, or a description when there is no email
+func CredentialsEmail(creds *google.Credentials) string {
+	if len(creds.JSON) > 0 {
+		var key struct {
+			Type        string `json:"type"`
+			ClientEmail string `json:"client_email"`
+		}
+		if err := json.Unmarshal(creds.JSON, &key); err == nil {
+			if key.ClientEmail != "" {
+				return key.ClientEmail
+			}
+			return key.Type
+		}
+	}
+
+	if metadata.OnGCE() {
+		email, err := metadata.Email("default")
+		if err == nil {
+			return email
+		}
+		log.Debugf("error getting the service account email from the metadata server %v", err)
+	}
+	return "unknown"
+}
+
+type impersonatedTokenSource struct {
+	ctx       context.Context
+	service   *iamcredentials.Service
+	target    string
+	delegates []string
+}
+
+func serviceAccountResourceName(email string) string {
+	return fmt.Sprintf("projects/-/serviceAccounts/%s", email)
+}
+
+// Token generates an access token for the target service account
+func (i *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	var delegates []string
+	for _, delegate := range i.delegates {
+		delegates = append(delegates, serviceAccountResourceName(delegate))
+	}
+	req := &iamcredentials.GenerateAccessTokenRequest{
+		Delegates: delegates,
+		Scope:     []string{CloudPlatformScope},
+	}
+
+	log.Debugf("Generating access token for service account %s with delegates %v", i.target, i.delegates)
+	resp, err := i.service.Projects.ServiceAccounts.GenerateAccessToken(serviceAccountResourceName(i.target), req).Context(i.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error impersonating service account %s, the caller needs roles/iam.serviceAccountTokenCreator on it %v", i.target, err)
+	}
+
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing impersonated access token expiry %v", err)
+	}
+	return &oauth2.Token{AccessToken: resp.AccessToken, TokenType: "Bearer", Expiry: expiry}, nil
+}
+
+// ImpersonatedTokenSource returns a token source for the target service account using the IAM Credentials
+// generateAccessToken API, delegates is the chain of service accounts between the caller and the target
+func ImpersonatedTokenSource(ctx context.Context, target string, delegates []string, opts ...option.ClientOption) (oauth2.TokenSource, error) {
+	service, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating IAM Credentials client %v", err)
+	}
+	tokenSource := &impersonatedTokenSource{
+		ctx:       ctx,
+		service:   service,
+		target:    target,
+		delegates: delegates,
+	}
+	return oauth2.ReuseTokenSource(nil, tokenSource), nil
+}
+
+// SignJWT signs the JWT claims payload as the target service account using the IAM Credentials signJwt API,
+// delegates is the chain of service accounts between the caller and the target
+func SignJWT(ctx context.Context, target string, delegates []string, payload string, opts ...option.ClientOption) (string, error) {
+	service, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("error creating IAM Credentials client %v", err)
+	}
+
+	var delegateNames []string
+	for _, delegate := range delegates {
+		delegateNames = append(delegateNames, serviceAccountResourceName(delegate))
+	}
+	req := &iamcredentials.SignJwtRequest{
+		Delegates: delegateNames,
+		Payload:   payload,
+	}
+
+	log.Debugf("Signing JWT for service account %s with delegates %v", target, delegates)
+	resp, err := service.Projects.ServiceAccounts.SignJwt(serviceAccountResourceName(target), req).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT as service account %s, the caller needs roles/iam.serviceAccountTokenCreator on it %v", target, err)
+	}
+	return resp.SignedJwt, nil
+}
+
+// identity returns the email used to access the secrets, for logging
+func identity(ctx context.Context, cfg *Config) string {
+	if cfg.ImpersonateServiceAccount != "" {
+		return cfg.ImpersonateServiceAccount
+	}
+	creds, err := FindCredentials(ctx)
+	if err != nil {
+		log.Debug(err)
+		return "unknown"
+	}
+	return CredentialsEmail(creds)
+}
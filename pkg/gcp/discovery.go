@@ -0,0 +1,166 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	gax "github.com/googleapis/gax-go/v2"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+	secretspb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+)
+
+// SecretManagerDiscoveryClient interface, lists the secrets and their versions
+type SecretManagerDiscoveryClient interface {
+	SecretManagerClient
+	ListSecrets(ctx context.Context, req *secretspb.ListSecretsRequest, opts ...gax.CallOption) *secretmanager.SecretIterator
+	ListSecretVersions(ctx context.Context, req *secretspb.ListSecretVersionsRequest, opts ...gax.CallOption) *secretmanager.SecretVersionIterator
+}
+
+// ParseLabels parse a list of key=value strings into a labels map
+func ParseLabels(labels []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(labels))
+	for _, label := range labels {
+		split := strings.SplitN(label, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("bad label filter %q, expected key=value", label)
+		}
+		parsed[split[0]] = split[1]
+	}
+	return parsed, nil
+}
+
+func isDiscovery(cfg *Config) bool {
+	return cfg.Filter != "" || len(cfg.Labels) > 0
+}
+
+// BuildListSecretsFilter joins the labels and the filter expression into a ListSecrets filter
+func BuildListSecretsFilter(cfg *Config) string {
+	keys := make([]string, 0, len(cfg.Labels))
+	for key := range cfg.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var filters []string
+	for _, key := range keys {
+		filters = append(filters, fmt.Sprintf("labels.%s=%s", key, cfg.Labels[key]))
+	}
+	if cfg.Filter != "" {
+		filters = append(filters, fmt.Sprintf("(%s)", cfg.Filter))
+	}
+	return strings.Join(filters, " AND ")
+}
+
+func secretsParent(cfg *Config) (string, error) {
+	if cfg.ProjectID == "" {
+		return "", errors.New("project ID is missing, pass it via --project-id to discover secrets")
+	}
+	if cfg.Location != "" {
+		return fmt.Sprintf("projects/%s/locations/%s", cfg.ProjectID, cfg.Location), nil
+	}
+	return fmt.Sprintf("projects/%s", cfg.ProjectID), nil
+}
+
+// envNameFromLabel returns the secret NameLabel value as an env var name, or the secret ID
+// without the label, both normalized with envName
+func envNameFromLabel(secret *secretspb.Secret, label string) string {
+	id := secretIDFromName(secret.Name)
+	if label == "" {
+		return envName(id)
+	}
+	value, ok := secret.Labels[label]
+	if !ok || value == "" {
+		log.Debugf("Secret %s has no %s label, using the secret ID", secret.Name, label)
+		return envName(id)
+	}
+	return envName(value)
+}
+
+// envName returns a label value or secret ID as an env var name, label values are lower case
+// and secret IDs can have dashes and dots, so the value is upper cased and dashes and dots
+// replaced with underscores
+func envName(value string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(value))
+}
+
+// latestEnabledVersion returns the newest enabled version name of the secret, or an empty string
+func latestEnabledVersion(ctx context.Context, client SecretManagerDiscoveryClient, secretName string) (string, error) {
+	it := client.ListSecretVersions(ctx, &secretspb.ListSecretVersionsRequest{
+		Parent: secretName,
+		Filter: "state:ENABLED",
+	})
+
+	var latest *secretspb.SecretVersion
+	for {
+		version, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to list versions of secret %s: %v", secretName, err)
+		}
+		if version.State != secretspb.SecretVersion_ENABLED {
+			continue
+		}
+		if latest == nil || version.CreateTime.AsTime().After(latest.CreateTime.AsTime()) {
+			latest = version
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	return latest.Name, nil
+}
+
+// DiscoverSecrets list the secrets matching the labels and filter, and returns a raw secret config
+// for the latest enabled version of each, named after the NameLabel value or the secret ID
+func DiscoverSecrets(ctx context.Context, client SecretManagerDiscoveryClient, cfg *Config) ([]SecretConfig, error) {
+	parent, err := secretsParent(cfg)
+	if err != nil {
+		return nil, err
+	}
+	filter := BuildListSecretsFilter(cfg)
+	log.Debugf("Listing secrets in %s with filter: %q", parent, filter)
+
+	var secrets []*secretspb.Secret
+	it := client.ListSecrets(ctx, &secretspb.ListSecretsRequest{Parent: parent, Filter: filter})
+	for {
+		secret, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %v", err)
+		}
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+
+	var secretsConfigList []SecretConfig
+	for _, secret := range secrets {
+		version, err := latestEnabledVersion(ctx, client, secret.Name)
+		if err != nil {
+			return nil, err
+		}
+		if version == "" {
+			log.Warnf("secret %s has no enabled version, skipping it", secret.Name)
+			continue
+		}
+		secretsConfigList = append(secretsConfigList, SecretConfig{
+			Name:    version,
+			Format:  FormatRaw,
+			EnvName: envNameFromLabel(secret, cfg.NameLabel),
+		})
+	}
+
+	if len(secretsConfigList) == 0 {
+		return nil, fmt.Errorf("could not find enabled secrets in %s with filter: %q", parent, filter)
+	}
+	log.Debugf("Discovered secrets: %v", secretsConfigList)
+	return secretsConfigList, nil
+}
@@ -0,0 +1,26 @@
+package gcp
+
+import (
+	"fmt"
+)
+
+// AccessSecretVersions accesses each secret version resource name once, names without
+// a version access the latest one, and returns the payload of each name
+func AccessSecretVersions(client SecretManagerClient, names []string) (map[string]interface{}, error) {
+	secrets := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if !IsResourceName(name) {
+			return nil, fmt.Errorf("bad secret resource name %s, expected projects/<project>[/locations/<location>]/secrets/<secret>[/versions/<version>]", name)
+		}
+		accessRequest, err := BuildAccessSecretRequest(&SecretManagerAccessRequestParams{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		payload, err := GetSecretData(client, accessRequest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		secrets[name] = string(payload.Data)
+	}
+	return secrets, nil
+}
@@ -3,14 +3,20 @@ package gcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"path"
+	"regexp"
+	"strings"
 
-	"github.com/doitintl/secrets-consumer-env/pkg/vault"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 
-	secretmanager "cloud.google.com/go/secretmanager/apiv1beta1"
-	secretspb "google.golang.org/genproto/googleapis/cloud/secrets/v1beta1"
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"google.golang.org/api/option"
+	secretspb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
 
 	gax "github.com/googleapis/gax-go/v2"
 	grpccodes "google.golang.org/grpc/codes"
@@ -19,12 +25,91 @@ import (
 
 // Config - configuration for GCP
 type Config struct {
-	ProjectID                    string
-	SecretName                   string
+	ProjectID string
+	// SecretName is either a secret ID or a full resource name like projects/p/secrets/s/versions/3
+	SecretName string
+	// Location of a regional secret, e.g. us-central1, empty for global secrets
+	Location                     string
 	SecretVersion                string
 	GoogleApplicationCredentials string
 	ServiceAccount               string
-	UseInTests                   bool
+	// ImpersonateServiceAccount is the email of the service account to access the secrets as,
+	// through the ImpersonateDelegates chain of service accounts
+	ImpersonateServiceAccount string
+	ImpersonateDelegates      []string
+	UseInTests                bool
+	// SecretsConfigList holds additional secrets, fetched concurrently with SecretName
+	SecretsConfigList []SecretConfig
+	// Labels and Filter select the secrets to discover, each discovered secret is exported as is
+	// under the value of its NameLabel label or its secret ID
+	Labels    map[string]string
+	Filter    string
+	NameLabel string
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// Secret payload formats
+const (
+	FormatJSON = "json"
+	FormatRaw  = "raw"
+)
+
+// SecretConfig holds a single secret config
+type SecretConfig struct {
+	Name    string
+	Version string
+	// Format is FormatJSON to expand the payload keys or FormatRaw to export the payload as is
+	Format string
+	// EnvName is the variable name for a raw payload, defaults to the secret ID
+	EnvName string
+}
+
+// SecretConfigJSON JSON struct for secret config
+type SecretConfigJSON struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Format  string `json:"format"`
+	Env     string `json:"env"`
+}
+
+// ConfigureSecrets decode the JSON secret configs into SecretConfig list
+func ConfigureSecrets(secretConfigs []string) ([]SecretConfig, error) {
+	/*
+		secret config is a JSON string like
+		'{"name": "db-password", "version": "3", "format": "raw", "env": "DB_PASSWORD"}'
+		format defaults to json, or raw when env is set
+	*/
+	var secretsConfigList []SecretConfig
+
+	for _, secretConfigJSONString := range secretConfigs {
+		var secretConfigData SecretConfigJSON
+		err := json.Unmarshal([]byte(secretConfigJSONString), &secretConfigData)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode JSON from string %s - %+v", secretConfigJSONString, err)
+		}
+		if secretConfigData.Name == "" {
+			return nil, fmt.Errorf("secret config %s is missing the secret name", secretConfigJSONString)
+		}
+
+		format := strings.ToLower(secretConfigData.Format)
+		switch {
+		case format == "" && secretConfigData.Env != "":
+			format = FormatRaw
+		case format == "":
+			format = FormatJSON
+		case format != FormatJSON && format != FormatRaw:
+			return nil, fmt.Errorf("unknown format %q in secret config %s, expected json or raw", secretConfigData.Format, secretConfigJSONString)
+		}
+
+		secretsConfigList = append(secretsConfigList, SecretConfig{
+			Name:    secretConfigData.Name,
+			Version: secretConfigData.Version,
+			Format:  format,
+			EnvName: secretConfigData.Env,
+		})
+	}
+	return secretsConfigList, nil
 }
 
 // SecretManagerAccessRequestParams is used as input to access a secret from Secret Manager.
@@ -32,20 +117,97 @@ type SecretManagerAccessRequestParams struct {
 	// Project is the ID or number of the project from which to access secrets.
 	Project string
 
-	// Name is the name of the secret to access.
+	// Location is the region of a regional secret, empty for global secrets.
+	Location string
+
+	// Name is the name of the secret to access, or its full resource name.
 	Name string
 
-	// Version is the version of the secret to access.
+	// Version is the version number, "latest" or a version alias of the secret to access.
 	Version string
 }
 
+// secretResourceName matches projects/<project>[/locations/<location>]/secrets/<secret>[/versions/<version>]
+var secretResourceName = regexp.MustCompile(`^projects/([^/]+)(?:/locations/([^/]+))?/secrets/([^/]+)(?:/versions/([^/]+))?$`)
+
+// secretVersion matches a version number, "latest" or a version alias
+var secretVersion = regexp.MustCompile(`^(latest|[0-9]+|[a-zA-Z][a-zA-Z0-9_-]{0,62})$`)
+
+// IsResourceName reports whether the secret name is a full resource name
+func IsResourceName(name string) bool {
+	return strings.HasPrefix(name, "projects/")
+}
+
+// secretIDFromName returns the secret ID of a secret ID or full resource name
+func secretIDFromName(name string) string {
+	match := secretResourceName.FindStringSubmatch(name)
+	if match == nil {
+		return name
+	}
+	return match[3]
+}
+
+// LocationFromName returns the location of a regional secret full resource name
+func LocationFromName(name string) string {
+	match := secretResourceName.FindStringSubmatch(name)
+	if match == nil {
+		return ""
+	}
+	return match[2]
+}
+
 // SecretManagerClient interface
 type SecretManagerClient interface {
 	AccessSecretVersion(ctx context.Context, req *secretspb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error)
 }
 
-// GetSecretData will fetch the secret from secret manager
+// ErrPayloadCorrupted is returned when a secret payload does not match its CRC32C checksum
+var ErrPayloadCorrupted = errors.New("secret payload corrupted")
+
+// PayloadChecksumError holds the checksum returned by Secret Manager and the one computed on the payload
+type PayloadChecksumError struct {
+	Name     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *PayloadChecksumError) Error() string {
+	return fmt.Sprintf("%v: %s CRC32C checksum is %d, expected %d", ErrPayloadCorrupted, e.Name, e.Actual, e.Expected)
+}
+
+// Is reports whether the target is ErrPayloadCorrupted
+func (e *PayloadChecksumError) Is(target error) bool {
+	return target == ErrPayloadCorrupted
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// verifyPayload checks the payload data against the CRC32C checksum returned by Secret Manager
+func verifyPayload(name string, payload *secretspb.SecretPayload) error {
+	if payload.DataCrc32C == nil {
+		log.Debugf("No CRC32C checksum returned for %s, skipping payload verification", name)
+		return nil
+	}
+
+	checksum := int64(crc32.Checksum(payload.Data, crc32cTable))
+	log.Debugf("Secret %s CRC32C checksum: %d, computed: %d", name, payload.GetDataCrc32C(), checksum)
+	if checksum != payload.GetDataCrc32C() {
+		return &PayloadChecksumError{Name: name, Expected: payload.GetDataCrc32C(), Actual: checksum}
+	}
+	return nil
+}
+
+// GetSecretData will fetch the secret from secret manager and verify its checksum
 func GetSecretData(client SecretManagerClient, accessRequest *secretspb.AccessSecretVersionRequest) (*secretspb.SecretPayload, error) {
+	resp, err := accessSecretVersion(client, accessRequest)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+// accessSecretVersion access the secret version and verify the checksum of its payload
+func accessSecretVersion(client SecretManagerClient, accessRequest *secretspb.AccessSecretVersionRequest) (*secretspb.AccessSecretVersionResponse, error) {
 	ctx := context.Background()
 	resp, err := client.AccessSecretVersion(ctx, accessRequest)
 	if err != nil {
@@ -55,11 +217,22 @@ func GetSecretData(client SecretManagerClient, accessRequest *secretspb.AccessSe
 		}
 		return nil, fmt.Errorf("failed to access secret: %v", err)
 	}
-	return resp.Payload, nil
+	if resp.Payload == nil {
+		return nil, fmt.Errorf("no payload returned for secret %s", accessRequest.Name)
+	}
+
+	name := resp.Name
+	if name == "" {
+		name = accessRequest.Name
+	}
+	if err := verifyPayload(name, resp.Payload); err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
 
 // ExtractPayload decode JSON respose from secret data
-func ExtractPayload(payload secretspb.SecretPayload) (map[string]interface{}, error) {
+func ExtractPayload(payload *secretspb.SecretPayload) (map[string]interface{}, error) {
 	var secretData map[string]interface{}
 	err := json.Unmarshal(payload.Data, &secretData)
 	if err != nil {
@@ -68,11 +241,26 @@ func ExtractPayload(payload secretspb.SecretPayload) (map[string]interface{}, er
 	return secretData, nil
 }
 
-// NewSecretManagerClient create new secret manager client
-func NewSecretManagerClient() (*secretmanager.Client, error) {
+// NewSecretManagerClient create new secret manager client with the Application Default Credentials,
+// regional secrets are served from the location-specific endpoint
+func NewSecretManagerClient(cfg *Config) (*secretmanager.Client, error) {
 	log.Info("Creating new GCP Secret Manager client")
 	ctx := context.Background()
-	client, err := secretmanager.NewClient(ctx)
+	var opts []option.ClientOption
+	if cfg.Location != "" {
+		endpoint := fmt.Sprintf("secretmanager.%s.rep.googleapis.com:443", cfg.Location)
+		log.Debugf("Using regional Secret Manager endpoint: %s", endpoint)
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	if cfg.ImpersonateServiceAccount != "" {
+		log.Infof("Impersonating service account %s", cfg.ImpersonateServiceAccount)
+		tokenSource, err := ImpersonatedTokenSource(ctx, cfg.ImpersonateServiceAccount, cfg.ImpersonateDelegates)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithTokenSource(tokenSource))
+	}
+	client, err := secretmanager.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating secret manager client %v", err)
 	}
@@ -81,53 +269,141 @@ func NewSecretManagerClient() (*secretmanager.Client, error) {
 
 // BuildAccessSecretRequest from params
 func BuildAccessSecretRequest(s *SecretManagerAccessRequestParams) (*secretspb.AccessSecretVersionRequest, error) {
+	version := s.Version
+	if version == "" {
+		version = "latest"
+	}
+
+	var name string
+	switch {
+	case IsResourceName(s.Name):
+		match := secretResourceName.FindStringSubmatch(s.Name)
+		if match == nil {
+			return nil, fmt.Errorf("bad secret resource name %s, expected projects/<project>[/locations/<location>]/secrets/<secret>[/versions/<version>]", s.Name)
+		}
+		name = s.Name
+		if match[4] == "" {
+			name = fmt.Sprintf("%s/versions/%s", s.Name, version)
+		} else {
+			version = match[4]
+		}
+	case s.Project == "":
+		return nil, fmt.Errorf("project ID is missing for secret %s, pass it via --project-id or use a full resource name", s.Name)
+	case s.Location != "":
+		name = fmt.Sprintf("projects/%s/locations/%s/secrets/%s/versions/%s", s.Project, s.Location, s.Name, version)
+	default:
+		name = fmt.Sprintf("projects/%s/secrets/%s/versions/%s", s.Project, s.Name, version)
+	}
+
+	if !secretVersion.MatchString(version) {
+		return nil, fmt.Errorf("bad secret version %q, expected a version number, latest or a version alias", version)
+	}
+
 	accessRequest := &secretspb.AccessSecretVersionRequest{
-		Name: fmt.Sprintf("projects/%s/secrets/%s/versions/%s", s.Project, s.Name, s.Version),
+		Name: name,
 	}
 	return accessRequest, nil
+}
+
+// retrieveSecretConfig returns the secret data of the secret config and the version it was read from
+func retrieveSecretConfig(client SecretManagerClient, cfg *Config, secretConfig SecretConfig) (map[string]interface{}, string, error) {
+	params := &SecretManagerAccessRequestParams{
+		Project:  cfg.ProjectID,
+		Location: cfg.Location,
+		Name:     secretConfig.Name,
+		Version:  secretConfig.Version,
+	}
+
+	accessRequest, err := BuildAccessSecretRequest(params)
+	if err != nil {
+		return nil, "", err
+	}
+	log.Debugf("Accessing secret version %s", accessRequest.Name)
+	resp, err := accessSecretVersion(client, accessRequest)
+	if err != nil {
+		return nil, "", err
+	}
+	payload := resp.Payload
+	// the response names the version number of an alias like latest
+	version := resp.Name
+	if version == "" {
+		version = accessRequest.Name
+	}
+	version = path.Base(version)
+
+	if secretConfig.Format == FormatRaw {
+		name := secretConfig.EnvName
+		if name == "" {
+			name = secretIDFromName(secretConfig.Name)
+		}
+		return map[string]interface{}{name: string(payload.Data)}, version, nil
+	}
 
+	secretData, err := ExtractPayload(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v, use the raw format for plain text secrets", secretConfig.Name, err)
+	}
+	return secretData, version, nil
 }
 
 // RetrieveSecret Initialize client and get secret data
 func RetrieveSecret(client SecretManagerClient, cfg *Config) (map[string]interface{}, error) {
-	var (
-		logger *log.Entry
-		err    error
-	)
+	var logger *log.Entry
 
 	if !cfg.UseInTests {
-		sa, err := vault.GetServiceAccountCreds(&vault.GCPBackendConfig{Project: cfg.ProjectID, CredsPath: cfg.GoogleApplicationCredentials})
+		cfg.ServiceAccount = identity(context.Background(), cfg)
+	}
+
+	secretsConfigList := cfg.SecretsConfigList
+	if cfg.SecretName != "" {
+		secretsConfigList = append([]SecretConfig{{
+			Name:    cfg.SecretName,
+			Version: cfg.SecretVersion,
+			Format:  FormatJSON,
+		}}, secretsConfigList...)
+	}
+
+	// discovered secrets come first so the explicitly configured ones win
+	if isDiscovery(cfg) {
+		discoveryClient, ok := client.(SecretManagerDiscoveryClient)
+		if !ok {
+			return nil, errors.New("the secret manager client does not support listing secrets")
+		}
+		discovered, err := DiscoverSecrets(context.Background(), discoveryClient, cfg)
 		if err != nil {
 			return nil, err
 		}
-		cfg.ServiceAccount = sa.Email
+		secretsConfigList = append(discovered, secretsConfigList...)
 	}
 
 	logger = log.WithFields(logrus.Fields{
 		"project":         cfg.ProjectID,
+		"location":        cfg.Location,
 		"secret_name":     cfg.SecretName,
 		"secret_version":  cfg.SecretVersion,
+		"filter":          BuildListSecretsFilter(cfg),
+		"secrets":         len(secretsConfigList),
 		"service_account": cfg.ServiceAccount,
 	})
 
 	logger.Info("Getting secrets from GCP Secret Manager")
-	params := &SecretManagerAccessRequestParams{
-		Project: cfg.ProjectID,
-		Name:    cfg.SecretName,
-		Version: cfg.SecretVersion,
-	}
 
-	accessRequest, err := BuildAccessSecretRequest(params)
-	if err != nil {
-		return nil, err
+	names := make([]string, len(secretsConfigList))
+	for i, secretConfig := range secretsConfigList {
+		names[i] = secretConfig.Name
 	}
-	payload, err := GetSecretData(client, accessRequest)
+	versions := make([]string, len(secretsConfigList))
+	merger, err := merge.Fetch(names, func(i int) (map[string]interface{}, error) {
+		var secretData map[string]interface{}
+		var err error
+		secretData, versions[i], err = retrieveSecretConfig(client, cfg, secretsConfigList[i])
+		return secretData, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	secretData, err := ExtractPayload(*payload)
-	if err != nil {
-		return nil, err
+	for i, name := range names {
+		merger.SetVersion(name, versions[i])
 	}
-	return secretData, nil
+	return merger.Result(cfg.Recorder), nil
 }
@@ -0,0 +1,157 @@
+package httpjson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Config - configuration for a generic HTTP JSON secrets source
+type Config struct {
+	URL string
+	// BearerTokenFile is read on every request so rotated tokens are picked up
+	BearerTokenFile string
+	Username        string
+	Password        string
+	// Pointer is a JSON pointer (RFC 6901) to the object holding the secrets, like /data/secrets
+	Pointer string
+	TLS     tlsconfig.Config
+}
+
+// ErrPointerNotFound is returned when the JSON pointer does not match the response
+var ErrPointerNotFound = errors.New("JSON pointer not found")
+
+// Client gets the secrets document from the URL
+type Client struct {
+	URL             string
+	BearerTokenFile string
+	Username        string
+	Password        string
+	HTTPClient      *http.Client
+}
+
+// NewClient create a new HTTP client with the TLS settings
+func NewClient(cfg *Config) (*Client, error) {
+	log.Info("Creating new HTTP client")
+	if cfg.BearerTokenFile != "" && cfg.Username != "" {
+		return nil, fmt.Errorf("bearer token and basic authentication can not be used together")
+	}
+	tlsConfig, err := tlsconfig.New(&cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		URL:             cfg.URL,
+		BearerTokenFile: cfg.BearerTokenFile,
+		Username:        cfg.Username,
+		Password:        cfg.Password,
+		HTTPClient: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Get returns the decoded JSON document at the URL
+func (c *Client) Get() (interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.BearerTokenFile != "":
+		token, err := ioutil.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the bearer token file %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", c.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response of %s: %v", c.URL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get %s, status %d: %s", c.URL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("bad response from %s, can not decode JSON %v", c.URL, err)
+	}
+	return document, nil
+}
+
+// ResolvePointer returns the value of the document at the JSON pointer, the empty pointer is the whole document
+func ResolvePointer(document interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return document, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q, it must start with a \"/\"", pointer)
+	}
+
+	value := document
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch node := value.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrPointerNotFound, pointer)
+			}
+			value = child
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("%w: %s", ErrPointerNotFound, pointer)
+			}
+			value = node[index]
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPointerNotFound, pointer)
+		}
+	}
+	return value, nil
+}
+
+// RetrieveSecret get the JSON document and extract the secrets object at the pointer
+func RetrieveSecret(client *Client, cfg *Config) (map[string]interface{}, error) {
+	logger := log.WithFields(log.Fields{
+		"url":     client.URL,
+		"pointer": cfg.Pointer,
+	})
+	logger.Info("Getting secrets from HTTP")
+
+	document, err := client.Get()
+	if err != nil {
+		return nil, err
+	}
+	value, err := ResolvePointer(document, cfg.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	secretData, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the value at JSON pointer %q is not an object", cfg.Pointer)
+	}
+	return secretData, nil
+}
@@ -0,0 +1,41 @@
+package injector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Injection is an env var that would be set from the secrets
+type Injection struct {
+	Name    string
+	Source  string
+	Version string
+	Value   string
+	// Err is set when the secret key is missing or the reference failed to resolve
+	Err error
+}
+
+// Hash returns a short SHA-256 fingerprint of the value, to compare values without revealing them
+func (i Injection) Hash() string {
+	sum := sha256.Sum256([]byte(i.Value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// PlanInjections returns every env var InjectSecrets would set, in the environ order followed by
+// the secret data keys sorted by name, reporting every missing key, failed reference and secret key
+// colliding with the error precedence instead of stopping at the first one, source names the secrets
+// manager of the secret data, the error is for invalid options and the strict mode violations
+func PlanInjections(secretData map[string]interface{}, environ []string, source string, opts *Options) ([]Injection, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	entries, err := opts.plan(secretData, environ, source)
+	var injections []Injection
+	for _, entry := range entries {
+		// the explicit keys of the Vault env vars are not set either
+		if !entry.inherited && (exportable(entry.Name) || entry.Err != nil) {
+			injections = append(injections, entry.Injection)
+		}
+	}
+	return injections, err
+}
@@ -0,0 +1,49 @@
+package injector
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// validate checks the precedence policy and the key patterns
+func (opts *Options) validate() error {
+	switch opts.Precedence {
+	case "", PrecedenceSecretsWin, PrecedenceEnvWins, PrecedenceError:
+	default:
+		return fmt.Errorf("unknown precedence %q, expected one of %s", opts.Precedence, strings.Join(Precedences, ", "))
+	}
+	var patterns []string
+	for _, list := range [][]string{opts.Include, opts.Exclude, opts.Scrub, opts.Passthrough} {
+		patterns = append(patterns, list...)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad key pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// exported reports whether a secret key matches an include pattern, or there are none,
+// and no exclude pattern
+func (opts *Options) exported(name string) bool {
+	if len(opts.Include) > 0 && !matchAny(opts.Include, name) {
+		return false
+	}
+	return !matchAny(opts.Exclude, name)
+}
+
+// filterKeys returns the secret data of the exported keys
+func (opts *Options) filterKeys(data map[string]interface{}) map[string]interface{} {
+	if len(opts.Include) == 0 && len(opts.Exclude) == 0 {
+		return data
+	}
+	filtered := make(map[string]interface{}, len(data))
+	for name, value := range data {
+		if opts.exported(name) {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,139 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	log "github.com/sirupsen/logrus"
+)
+
+// formatValue returns the env var value of a secret value, strings as is, numbers without an
+// exponent, null as an empty string and objects and arrays encoded as JSON
+func formatValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	case json.Number:
+		return value.String()
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", value)
+	}
+	encoded, err := json.Marshal(jsonValue(value))
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
+
+// jsonValue converts the map[interface{}]interface{} objects of YAML documents to
+// map[string]interface{} for encoding/json
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, nested := range value {
+			object[fmt.Sprintf("%v", key)] = jsonValue(nested)
+		}
+		return object
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, nested := range value {
+			object[key] = jsonValue(nested)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(value))
+		for i, nested := range value {
+			array[i] = jsonValue(nested)
+		}
+		return array
+	}
+	return value
+}
+
+// flatten returns the secret data with the keys of nested objects joined to their parent key
+// with an underscore, like DB_USER for {"DB": {"USER": "admin"}}, arrays are kept as is. Literal
+// top-level keys win over the flattened keys colliding with them, like an explicit key lookup,
+// of other colliding keys the first in key order wins, the collisions are returned as conflicts
+// of the dotted key paths with the winning one last, like DB.USER and DB_USER
+func flatten(data map[string]interface{}) (map[string]interface{}, []merge.Conflict) {
+	flat := make(map[string]interface{}, len(data))
+	sources := make(map[string][]string)
+	nested := make(map[string]interface{})
+	for key, value := range data {
+		if _, ok := jsonValue(value).(map[string]interface{}); ok {
+			nested[key] = value
+			continue
+		}
+		flat[key] = value
+		sources[key] = []string{key}
+	}
+	flattenInto(flat, sources, "", "", nested)
+
+	var conflicts []merge.Conflict
+	for name, keyPaths := range sources {
+		if len(keyPaths) > 1 {
+			ordered := append(append([]string{}, keyPaths[1:]...), keyPaths[0])
+			conflicts = append(conflicts, merge.Conflict{Key: name, Sources: ordered})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Key < conflicts[j].Key })
+	return flat, conflicts
+}
+
+func flattenInto(flat map[string]interface{}, sources map[string][]string, prefix, keyPath string, object map[string]interface{}) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name, source := key, key
+		if prefix != "" {
+			name = prefix + "_" + key
+			source = keyPath + "." + key
+		}
+		if nested, ok := jsonValue(object[key]).(map[string]interface{}); ok {
+			flattenInto(flat, sources, name, source, nested)
+			continue
+		}
+		sources[name] = append(sources[name], source)
+		if _, exists := flat[name]; exists {
+			log.Warnf("Flattened secret key %s of %s is already set by %s, keeping its value", name, source, sources[name][0])
+			continue
+		}
+		flat[name] = object[key]
+	}
+}
+
+// secretValue looks a key up in the secret data, then in the flattened secret data
+func secretValue(data, flat map[string]interface{}, key string) (interface{}, bool) {
+	if value, ok := data[key]; ok {
+		return value, true
+	}
+	value, ok := flat[key]
+	return value, ok
+}
+
+// exportedData returns the secret data of the secret keys exported when no explicit keys are
+// referenced, flattened with the Flatten option along with the flattened keys collisions
+func (opts *Options) exportedData(data map[string]interface{}) (map[string]interface{}, []merge.Conflict) {
+	if opts.Flatten {
+		return flatten(data)
+	}
+	return data, nil
+}
@@ -2,62 +2,158 @@ package injector
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
 	vaultSecretsManager "github.com/doitintl/secrets-consumer-env/pkg/vault"
 	log "github.com/sirupsen/logrus"
 )
 
-// SanitizedEnviron will hold env without VAULT env vars
+// SanitizedEnviron will hold env without the scrubbed and VAULT env vars, each name once in the order it was first added
 type SanitizedEnviron []string
 
-// Vault known Env Vars
-var sanitizeEnvmap = map[string]bool{
-	"VAULT_TOKEN":           true,
-	"VAULT_ADDR":            true,
-	"VAULT_CACERT":          true,
-	"VAULT_CAPATH":          true,
-	"VAULT_CLIENT_CERT":     true,
-	"VAULT_CLIENT_KEY":      true,
-	"VAULT_CLIENT_TIMEOUT":  true,
-	"VAULT_CLUSTER_ADDR":    true,
-	"VAULT_MAX_RETRIES":     true,
-	"VAULT_REDIRECT_ADDR":   true,
-	"VAULT_SKIP_VERIFY":     true,
-	"VAULT_TLS_SERVER_NAME": true,
-	"VAULT_CLI_NO_COLOR":    true,
-	"VAULT_RATE_LIMIT":      true,
-	"VAULT_NAMESPACE":       true,
-	"VAULT_MFA":             true,
-	"VAULT_ROLE":            true,
-	"VAULT_PATH":            true,
-}
+// Precedence policies between the existing env vars and the secret keys with the same name
+const (
+	PrecedenceSecretsWin = "secrets-win"
+	PrecedenceEnvWins    = "env-wins"
+	PrecedenceError      = "error"
+)
+
+// Precedences lists the precedence policies
+var Precedences = []string{PrecedenceSecretsWin, PrecedenceEnvWins, PrecedenceError}
 
-// Appends variable an entry (name=value) into the environ list.
-// VAULT_* variables are not populated into this list.
+// Appends variable an entry (name=value) into the environ list, an entry with the same name
+// is replaced in place. VAULT_* variables are not populated into this list.
 func (environ *SanitizedEnviron) append(name, value string) {
-	if _, ok := sanitizeEnvmap[name]; !ok {
-		*environ = append(*environ, fmt.Sprintf("%s=%s", name, value))
+	if !exportable(name) {
+		return
+	}
+	entry := fmt.Sprintf("%s=%s", name, value)
+	if i := environ.index(name); i >= 0 {
+		(*environ)[i] = entry
+		return
+	}
+	*environ = append(*environ, entry)
+}
+
+// index returns the position of the entry with the name, -1 without one
+func (environ SanitizedEnviron) index(name string) int {
+	prefix := name + "="
+	for i, entry := range environ {
+		if strings.HasPrefix(entry, prefix) {
+			return i
+		}
 	}
+	return -1
+}
+
+// Options of the secrets injection
+type Options struct {
+	// Strict fails on the Conflicts, on the Flatten keys collisions, on secret keys colliding with
+	// existing env vars and on secret keys that are not valid env var names
+	Strict bool
+	// Conflicts are the secret keys set by more than one secret config, recorded by the providers
+	Conflicts []merge.Conflict
+	// Versions are the versions of the secret keys, recorded by the providers returning one
+	Versions map[string]string
+	// Precedence between an existing env var and a secret key with the same name,
+	// PrecedenceSecretsWin by default
+	Precedence string
+	// Include and Exclude are glob patterns, like DB_*, of the secret keys exported when no explicit
+	// keys are referenced, every key is included without include patterns
+	Include []string
+	Exclude []string
+	// Providers are the providers of the secret data, the env vars configuring them are scrubbed
+	Providers []string
+	// Scrub are glob patterns of more env vars not inherited by the child
+	Scrub []string
+	// CleanEnv only inherits the CleanEnviron env vars, like PATH and HOME
+	CleanEnv bool
+	// Passthrough are glob patterns of the env vars always inherited, even when scrubbed
+	Passthrough []string
+	// Flatten exports the keys of nested objects as PARENT_CHILD keys instead of a JSON value
+	Flatten bool
+	// Resolvers resolve the fully-qualified references by source, like vault, aws or gcp,
+	// the references of a source without one fail
+	Resolvers map[string]Resolver
 }
 
 // InjectSecrets into the sanitized env
 func InjectSecrets(secretData map[string]interface{}, environ []string, sanitized SanitizedEnviron) ([]string, error) {
+	return InjectSecretsWithOptions(secretData, environ, sanitized, &Options{})
+}
+
+// InjectSecretsWithOptions into the sanitized env
+func InjectSecretsWithOptions(secretData map[string]interface{}, environ []string, sanitized SanitizedEnviron, opts *Options) ([]string, error) {
 	/*
 		go over the current env vars
 		if the env var contains a vault: or secret: prefix it will be added to the sanitized env
+		if the env var is a fully-qualified reference (vault:<path>#<key>, aws:<secret>#<key>, gcp:projects/...)
+		its resolved value, piped through its transforms, will be added to the sanitized env
+		a value shaped like a reference, a <source>: prefix and a path with a / or a #<key>, that is not a valid one fails
 		if not add all key values from the secret data to the env vars
 	*/
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	entries, strictErr := opts.plan(secretData, environ, "secret")
+	for _, entry := range entries {
+		if entry.Err != nil && !entry.collides {
+			return nil, entry.Err
+		}
+	}
+	if strictErr != nil {
+		return nil, strictErr
+	}
+
+	var collisions []string
+	for _, entry := range entries {
+		if entry.collides {
+			collisions = append(collisions, entry.Name)
+			continue
+		}
+		sanitized.append(entry.Name, entry.Value)
+	}
+	if len(collisions) > 0 {
+		return nil, fmt.Errorf("secret keys are already set in the environment: %s, use another precedence policy or rename them", strings.Join(collisions, ", "))
+	}
+	return sanitized, nil
+}
+
+// planned is an env var of the command environment, inherited or injected from the secrets
+type planned struct {
+	Injection
+	inherited bool
+	// collides is set for a secret key already set in the environment with the error precedence
+	collides bool
+}
+
+// plan returns the env vars of the command environment in order, the inherited env vars and the
+// references and explicit keys of the environ followed by the secret keys sorted by name when no key
+// is explicitly referenced, a missing key or a failed reference has an error, source names the
+// secrets manager of the secret data, the error returned is the strict mode one
+func (opts *Options) plan(secretData map[string]interface{}, environ []string, source string) ([]planned, error) {
 	var data map[string]interface{}
 	var vaultSecretKey string
 	var prefixedEnv bool
 	var explicitKey bool
 
 	data = vaultSecretsManager.CastSecretDataToStringMap(secretData)
+	exported, flattened := opts.exportedData(data)
+	scrub := opts.scrubber(environ)
 
+	// fully-qualified references are resolved from their own sources, not the secret data
+	resolved, errs := resolveReferences(environ, opts.Resolvers)
+
+	var entries []planned
+	set := make(map[string]bool)
 	for _, env := range environ {
-		prefixedEnv = false
 		split := strings.SplitN(env, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
 		name := split[0]
 		value := split[1]
 
@@ -65,41 +161,106 @@ func InjectSecrets(secretData map[string]interface{}, environ []string, sanitize
 			value = strings.TrimPrefix(value, ">>")
 		}
 
-		hasVaultPrefix := strings.HasPrefix(value, "vault:")
-		hasSecretPrefix := strings.HasPrefix(value, "secret:")
-
-		if hasVaultPrefix {
-			// API_KEY=secret:API_KEY
-			vaultSecretKey = strings.TrimPrefix(value, "vault:")
-			prefixedEnv = true
+		if ref, stages, ok := parseReferenceValue(value); ok {
+			injection := Injection{Name: name, Source: value, Version: referenceVersion(ref), Err: errs[ref]}
+			pipeline, err := parseTransforms("Reference "+ref.String(), stages)
+			switch {
+			case err != nil:
+				injection.Err = err
+			case injection.Err == nil:
+				log.Debugf("Reference %s resolved, will be added to the process environment", ref)
+				injection.Value, injection.Err = applyTransforms("Reference "+ref.String(), resolved[ref], pipeline)
+			}
+			entries = append(entries, planned{Injection: injection})
+			set[name] = true
+			continue
 		}
-
-		if hasSecretPrefix {
-			vaultSecretKey = strings.TrimPrefix(value, "secret:")
-			prefixedEnv = true
+		if err := invalidReference(value); err != nil {
+			entries = append(entries, planned{Injection: Injection{Name: name, Source: value, Version: "-", Err: err}})
+			set[name] = true
+			continue
 		}
 
+		vaultSecretKey, prefixedEnv = explicitSecretKey(value)
+
 		if prefixedEnv == true {
 			// if the secret data contains an explicit key from env add it to the sanitized env
 			log.Debugf("Explicit key: %s found in env vars, checking if its in vault secrets...", vaultSecretKey)
 			explicitKey = true
-			if value, ok := data[vaultSecretKey]; ok {
-				log.Debugf("Explicit key: %s found, will be added to the process environment", vaultSecretKey)
-				sanitized.append(name, fmt.Sprintf("%v", value))
+			injection := Injection{Name: name, Source: fmt.Sprintf("%s:%s", source, vaultSecretKey), Version: "-"}
+			key, pipeline, err := splitTransforms(vaultSecretKey)
+			if err != nil {
+				injection.Err = err
+			} else if value, ok := secretValue(data, exported, key); ok {
+				log.Debugf("Explicit key: %s found, will be added to the process environment", key)
+				injection.Version = opts.version(key)
+				injection.Value, injection.Err = applyTransforms("Explicit key: "+key, value, pipeline)
 			} else {
-				return nil, fmt.Errorf("Explicit key: %s not found in secrets keys", vaultSecretKey)
+				injection.Err = fmt.Errorf("Explicit key: %s not found in secrets keys", key)
 			}
-		} else {
+			entries = append(entries, planned{Injection: injection})
+			set[name] = true
+		} else if scrub.inherited(name) && exportable(name) {
 			// add the env var to the sanitized env
-			sanitized.append(name, value)
+			entries = append(entries, planned{Injection: Injection{Name: name, Value: value}, inherited: true})
+			set[name] = true
+		}
+	}
+
+	if explicitKey {
+		return entries, opts.strictError(data, scrub.environ(environ), flattened, explicitKey)
+	}
+	data = opts.filterKeys(exported)
+
+	// the secret keys are added sorted so the environment is deterministic
+	names := make([]string, 0, len(data))
+	for secretName := range data {
+		if exportable(secretName) {
+			names = append(names, secretName)
 		}
 	}
+	sort.Strings(names)
 
-	if !explicitKey {
-		for secretName, secretValue := range data {
-			value := fmt.Sprintf("%v", secretValue)
-			sanitized.append(secretName, value)
+	for _, secretName := range names {
+		entry := planned{Injection: Injection{
+			Name:    secretName,
+			Source:  fmt.Sprintf("%s:%s", source, secretName),
+			Version: opts.version(secretName),
+			Value:   formatValue(data[secretName]),
+		}}
+		if set[secretName] {
+			switch opts.Precedence {
+			case PrecedenceEnvWins:
+				log.Debugf("Secret key %s is already set in the environment, keeping the env var", secretName)
+				continue
+			case PrecedenceError:
+				entry.collides = true
+				entry.Err = fmt.Errorf("secret key %s is already set in the environment, use another precedence policy or rename it", secretName)
+			default:
+				log.Debugf("Secret key %s is already set in the environment, overriding the env var", secretName)
+			}
 		}
+		entries = append(entries, entry)
 	}
-	return sanitized, nil
+	return entries, opts.strictError(data, scrub.environ(environ), flattened, explicitKey)
+}
+
+// version returns the version of the secret key reported by its provider, "-" when unknown
+func (opts *Options) version(key string) string {
+	if version, ok := opts.Versions[key]; ok {
+		return version
+	}
+	return "-"
+}
+
+// explicitSecretKey returns the secret key of a vault:<KEY> or secret:<KEY> env var value
+func explicitSecretKey(value string) (string, bool) {
+	switch {
+	case strings.HasPrefix(value, "vault:"):
+		// API_KEY=vault:API_KEY
+		return strings.TrimPrefix(value, "vault:"), true
+	case strings.HasPrefix(value, "secret:"):
+		return strings.TrimPrefix(value, "secret:"), true
+	}
+	return "", false
 }
@@ -0,0 +1,212 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reference is a fully-qualified secret reference naming its own source, like
+// vault:secret/data/db#password, aws:prod/db#password or gcp:projects/p/secrets/db/versions/3
+type Reference struct {
+	Source string
+	Path   string
+	// Key selects a key of a JSON secret, the whole secret is used without one
+	Key string
+}
+
+func (ref Reference) String() string {
+	if ref.Key == "" {
+		return fmt.Sprintf("%s:%s", ref.Source, ref.Path)
+	}
+	return fmt.Sprintf("%s:%s#%s", ref.Source, ref.Path, ref.Key)
+}
+
+// Resolver reads every path of its source in one batch and returns the secret of each path,
+// either a string or a map[string]interface{} of keys
+type Resolver func(paths []string) (map[string]interface{}, error)
+
+// referenceSyntax is <source>:<path>[#<key>], the paths of each source are checked by referencePaths
+var referenceSyntax = regexp.MustCompile(`^([a-z]+):([^\s#|]+)(?:#([^\s#|]+))?$`)
+
+// referenceShape matches the values shaped like a reference, a source prefix followed by a path
+// with a / or a #<key>, unlike the vault:<KEY> lookups
+var referenceShape = regexp.MustCompile(`^([a-z]+):\S*[/#]\S*$`)
+
+// referenceForms are the reference forms of each source for the errors
+var referenceForms = map[string]string{
+	"vault": "vault:<path>[#<key>] with a path like secret/data/db",
+	"aws":   "aws:<secret name or ARN>[#<key>]",
+	"gcp":   "gcp:projects/<project>/secrets/<secret>[/versions/<version>][#<key>]",
+}
+
+// referencePaths are the secret paths of each source
+var referencePaths = map[string]*regexp.Regexp{
+	"vault": regexp.MustCompile(`^[\w.-]+(/[\w.-]+)+$`),
+	// a secret name or ARN
+	"aws": regexp.MustCompile(`^[\w+=.@-][\w/+=.@:-]*$`),
+	"gcp": regexp.MustCompile(`^projects/[^/]+(/locations/[^/]+)?/secrets/[^/]+(/versions/[^/]+)?$`),
+}
+
+// ParseReference parse a fully-qualified reference, ok is false for any other value, so a value
+// not shaped like a reference is not one, like the vault:<KEY> and secret:<KEY> lookups of already
+// fetched keys or a plain aws:note value
+func ParseReference(value string) (ref Reference, ok bool) {
+	match := referenceSyntax.FindStringSubmatch(value)
+	if match == nil || !referenceShape.MatchString(value) {
+		return Reference{}, false
+	}
+	paths, ok := referencePaths[match[1]]
+	if !ok || !paths.MatchString(match[2]) {
+		return Reference{}, false
+	}
+	return Reference{Source: match[1], Path: match[2], Key: match[3]}, true
+}
+
+// invalidReference returns an error for a value shaped like a reference of a source that is not a
+// valid one, like vault:db#password without a secret path, so it is not passed on as is
+func invalidReference(value string) error {
+	head := splitPipeline(value)[0]
+	match := referenceShape.FindStringSubmatch(head)
+	if match == nil {
+		return nil
+	}
+	if _, ok := referencePaths[match[1]]; !ok {
+		return nil
+	}
+	if _, ok := ParseReference(head); ok {
+		return nil
+	}
+	return fmt.Errorf("%s is not a valid %s reference, expected %s", head, match[1], referenceForms[match[1]])
+}
+
+// parseReferenceValue parses an env var value holding a reference, and the transform stages piped
+// after it like vault:secret/data/tls#key|base64decode
+func parseReferenceValue(value string) (ref Reference, stages []string, ok bool) {
+	split := splitPipeline(value)
+	ref, ok = ParseReference(split[0])
+	return ref, split[1:], ok
+}
+
+// resolveReferences resolves the references in the environ with the resolvers by source, batched
+// per source, a reference failing to resolve gets an error instead of a value
+func resolveReferences(environ []string, resolvers map[string]Resolver) (map[Reference]string, map[Reference]error) {
+	paths := make(map[string]map[string]bool)
+	var refs []Reference
+	for _, env := range environ {
+		split := strings.SplitN(env, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+		value := split[1]
+		if strings.HasPrefix(value, ">>vault:") {
+			value = strings.TrimPrefix(value, ">>")
+		}
+		ref, _, ok := parseReferenceValue(value)
+		if !ok {
+			continue
+		}
+		if paths[ref.Source] == nil {
+			paths[ref.Source] = make(map[string]bool)
+		}
+		paths[ref.Source][ref.Path] = true
+		refs = append(refs, ref)
+	}
+
+	secrets := make(map[string]map[string]interface{})
+	sourceErrs := make(map[string]error)
+	for source, sourcePaths := range paths {
+		resolver, ok := resolvers[source]
+		if !ok {
+			sourceErrs[source] = fmt.Errorf("no resolver for %s references", source)
+			continue
+		}
+		batch := make([]string, 0, len(sourcePaths))
+		for p := range sourcePaths {
+			batch = append(batch, p)
+		}
+		sort.Strings(batch)
+
+		log.Debugf("Resolving %d %s references", len(batch), source)
+		secret, err := resolver(batch)
+		if err != nil {
+			sourceErrs[source] = fmt.Errorf("failed to resolve %s references: %v", source, err)
+			continue
+		}
+		secrets[source] = secret
+	}
+
+	resolved := make(map[Reference]string, len(refs))
+	errs := make(map[Reference]error)
+	for _, ref := range refs {
+		if err, ok := sourceErrs[ref.Source]; ok {
+			errs[ref] = err
+			continue
+		}
+		secret, ok := secrets[ref.Source][ref.Path]
+		if !ok {
+			errs[ref] = fmt.Errorf("reference %s not resolved", ref)
+			continue
+		}
+		value, err := referenceValue(ref, secret)
+		if err != nil {
+			errs[ref] = err
+			continue
+		}
+		resolved[ref] = value
+	}
+	return resolved, errs
+}
+
+// referenceVersion returns the version a reference reads
+func referenceVersion(ref Reference) string {
+	switch ref.Source {
+	case "gcp":
+		if i := strings.Index(ref.Path, "/versions/"); i >= 0 {
+			return ref.Path[i+len("/versions/"):]
+		}
+		return "latest"
+	case "aws":
+		return "AWSCURRENT"
+	}
+	return "latest"
+}
+
+// referenceValue selects the key of the reference from the secret, JSON objects are encoded
+// back to JSON when the reference has no key
+func referenceValue(ref Reference, secret interface{}) (string, error) {
+	if ref.Key == "" {
+		switch secret := secret.(type) {
+		case string:
+			return secret, nil
+		case []byte:
+			return string(secret), nil
+		}
+		value, err := json.Marshal(secret)
+		if err != nil {
+			return "", fmt.Errorf("reference %s: %v", ref, err)
+		}
+		return string(value), nil
+	}
+
+	var object map[string]interface{}
+	switch secret := secret.(type) {
+	case map[string]interface{}:
+		object = secret
+	case string:
+		if err := json.Unmarshal([]byte(secret), &object); err != nil {
+			return "", fmt.Errorf("reference %s: the secret is not a JSON object", ref)
+		}
+	default:
+		return "", fmt.Errorf("reference %s: the secret is not a JSON object", ref)
+	}
+	value, ok := object[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("reference %s: key %s not found in the secret", ref, ref.Key)
+	}
+	return formatValue(value), nil
+}
@@ -0,0 +1,217 @@
+package injector
+
+import (
+	"path"
+	"strings"
+)
+
+// commonScrub are the env vars configuring secrets-consumer-env itself
+var commonScrub = []string{
+	"SECRETS_STRICT",
+	"SECRETS_PRECEDENCE",
+	"SECRETS_INCLUDE",
+	"SECRETS_EXCLUDE",
+	"SECRETS_SCRUB",
+	"SECRETS_CLEAN_ENV",
+	"SECRETS_PASSTHROUGH",
+	"SECRETS_FLATTEN",
+	"RENDER_FORMAT",
+	"RENDER_OUTPUT",
+}
+
+// providerScrub are the patterns of the env vars configuring each provider, or holding the
+// credentials it logs in with
+var providerScrub = map[string][]string{
+	"vault": {
+		"VAULT_*",
+		"KUBERNETES_BACKEND",
+		"TOKEN_PATH",
+		"PROJECT_ID",
+		"GOOGLE_APPLICATION_CREDENTIALS",
+		"GCP_LOGIN_TYPE",
+		"GCP_MOUNT_PATH",
+		"GCP_JWT_EXPIRY",
+		"IMPERSONATE_SERVICE_ACCOUNT",
+		"IMPERSONATE_DELEGATES",
+	},
+	"aws": {
+		"AWS_ACCESS_KEY_ID",
+		"AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE",
+		"AWS_ROLE_ARN",
+		"AWS_ROLE_SESSION_NAME",
+		"AWS_ENDPOINT_URL",
+		"AWS_STS_ENDPOINT",
+		"AWS_USE_FIPS_ENDPOINT",
+		"AWS_USE_DUALSTACK_ENDPOINT",
+		"AWS_SECRET_NAME_PREFIX",
+		"AWS_SECRET_TAGS",
+		"AWS_USE_SECRET_NAMES_AS_KEYS",
+		"AWS_MAX_RETRIES",
+		"AWS_TIMEOUT",
+		"ROLE_ARN",
+		"SECRET_NAME",
+		"PREVIOUS_VERSION",
+		"REGION",
+	},
+	"gcp": {
+		"GOOGLE_APPLICATION_CREDENTIALS",
+		"PROJECT_ID",
+		"SECRET_NAME",
+		"SECRET_VERSION",
+		"SECRET_LOCATION",
+		"SECRET_LABELS",
+		"SECRET_FILTER",
+		"SECRET_NAME_LABEL",
+		"IMPERSONATE_SERVICE_ACCOUNT",
+		"IMPERSONATE_DELEGATES",
+	},
+	"azure": {
+		"AZURE_*",
+		"SECRET_NAME",
+	},
+	"kubernetes": {
+		"KUBERNETES_SECRETS",
+		"KUBERNETES_NAMESPACE",
+		"KUBERNETES_API_SERVER",
+		"KUBERNETES_CA_CERT",
+		"TOKEN_PATH",
+	},
+	"file": {
+		"SECRETS_FILES",
+		"SECRETS_FILE_FORMAT",
+		"SOPS",
+		"SOPS_*",
+	},
+	"consul": {
+		"CONSUL_*",
+	},
+	"etcd": {
+		"ETCDCTL_*",
+		"ETCD_KEYS",
+	},
+	"onepassword": {
+		"OP_CONNECT_*",
+		"OP_ITEMS",
+	},
+	"bitwarden": {
+		"BW_*",
+	},
+	"http": {
+		"HTTP_SECRETS_URL",
+		"HTTP_BEARER_TOKEN_FILE",
+		"HTTP_USERNAME",
+		"HTTP_PASSWORD",
+		"HTTP_JSON_POINTER",
+		"HTTP_CACERT",
+		"HTTP_CLIENT_CERT",
+		"HTTP_CLIENT_KEY",
+	},
+}
+
+// vaultEnv are the Vault env vars never set in the command environment
+var vaultEnv = map[string]bool{
+	"VAULT_TOKEN":           true,
+	"VAULT_ADDR":            true,
+	"VAULT_CACERT":          true,
+	"VAULT_CAPATH":          true,
+	"VAULT_CLIENT_CERT":     true,
+	"VAULT_CLIENT_KEY":      true,
+	"VAULT_CLIENT_TIMEOUT":  true,
+	"VAULT_CLUSTER_ADDR":    true,
+	"VAULT_MAX_RETRIES":     true,
+	"VAULT_REDIRECT_ADDR":   true,
+	"VAULT_SKIP_VERIFY":     true,
+	"VAULT_TLS_SERVER_NAME": true,
+	"VAULT_CLI_NO_COLOR":    true,
+	"VAULT_RATE_LIMIT":      true,
+	"VAULT_NAMESPACE":       true,
+	"VAULT_MFA":             true,
+	"VAULT_ROLE":            true,
+	"VAULT_PATH":            true,
+}
+
+// exportable reports whether an env var can be set in the command environment, the Vault env vars can not
+func exportable(name string) bool {
+	return !vaultEnv[name]
+}
+
+// CleanEnviron are the patterns of the env vars kept from the environment in the clean env mode,
+// on top of the passthrough patterns
+var CleanEnviron = []string{"PATH", "HOME", "USER", "TZ", "LANG", "LC_*"}
+
+// ScrubPatterns returns the patterns of the env vars scrubbed for the providers, on top of the
+// env vars configuring secrets-consumer-env
+func ScrubPatterns(providers ...string) []string {
+	patterns := append([]string{}, commonScrub...)
+	for _, provider := range providers {
+		patterns = append(patterns, providerScrub[provider]...)
+	}
+	return patterns
+}
+
+// scrubber decides which env vars are kept from the environment
+type scrubber struct {
+	scrub       []string
+	passthrough []string
+	clean       bool
+}
+
+// scrubber returns the scrubber of the options, the sources of the references in the environ
+// configure their providers too
+func (opts *Options) scrubber(environ []string) *scrubber {
+	providers := append([]string{}, opts.Providers...)
+	for _, env := range environ {
+		split := strings.SplitN(env, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+		if ref, _, ok := parseReferenceValue(strings.TrimPrefix(split[1], ">>")); ok {
+			providers = append(providers, ref.Source)
+		}
+	}
+	return &scrubber{
+		scrub:       append(ScrubPatterns(providers...), opts.Scrub...),
+		passthrough: opts.Passthrough,
+		clean:       opts.CleanEnv,
+	}
+}
+
+// scrubbed reports whether the env var name matches a scrub pattern
+func (s *scrubber) scrubbed(name string) bool {
+	return matchAny(s.scrub, name)
+}
+
+// inherited reports whether an env var is kept from the environment, passthrough env vars
+// always are
+func (s *scrubber) inherited(name string) bool {
+	if matchAny(s.passthrough, name) {
+		return true
+	}
+	if s.clean {
+		return matchAny(CleanEnviron, name)
+	}
+	return !s.scrubbed(name)
+}
+
+// environ returns the env vars of the environ kept by the scrubber
+func (s *scrubber) environ(environ []string) []string {
+	kept := make([]string, 0, len(environ))
+	for _, env := range environ {
+		if s.inherited(strings.SplitN(env, "=", 2)[0]) {
+			kept = append(kept, env)
+		}
+	}
+	return kept
+}
+
+// matchAny reports whether the name matches any of the glob patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package injector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+)
+
+// envName matches the POSIX env var names, letters, digits and underscores not starting with a digit
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// StrictError reports every strict mode violation
+type StrictError struct {
+	Violations []string
+}
+
+func (e *StrictError) Error() string {
+	return fmt.Sprintf("strict mode found %d violations: %s", len(e.Violations), strings.Join(e.Violations, "; "))
+}
+
+// strictViolations returns the conflicts of the secret keys set by more than one secret config and,
+// when every secret key is exported, the keys colliding with the env vars or that are not valid env var names
+func strictViolations(data map[string]interface{}, environ []string, conflicts []merge.Conflict, explicitKey bool) []string {
+	var violations []string
+	for _, conflict := range conflicts {
+		violations = append(violations, conflict.String())
+	}
+	if explicitKey {
+		return violations
+	}
+
+	existing := make(map[string]bool, len(environ))
+	for _, env := range environ {
+		existing[strings.SplitN(env, "=", 2)[0]] = true
+	}
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !exportable(name) {
+			continue
+		}
+		if existing[name] {
+			violations = append(violations, fmt.Sprintf("secret key %s collides with the env var %s", name, name))
+		}
+		if !envName.MatchString(name) {
+			violations = append(violations, fmt.Sprintf("secret key %q is not a valid env var name", name))
+		}
+	}
+	return violations
+}
+
+// strictError returns the strict mode violations as a StrictError, nil when not strict or without any,
+// the flattened keys collisions are conflicts too
+func (opts *Options) strictError(data map[string]interface{}, environ []string, flattened []merge.Conflict, explicitKey bool) error {
+	if !opts.Strict {
+		return nil
+	}
+	conflicts := append(append([]merge.Conflict{}, opts.Conflicts...), flattened...)
+	if violations := strictViolations(data, environ, conflicts, explicitKey); len(violations) > 0 {
+		return &StrictError{Violations: violations}
+	}
+	return nil
+}
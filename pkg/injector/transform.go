@@ -0,0 +1,201 @@
+package injector
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// transform of a secret value, arg is the text after the colon, like the path of jsonpath:$.db.password
+type transform struct {
+	name string
+	arg  string
+}
+
+func (t transform) String() string {
+	if t.arg == "" {
+		return t.name
+	}
+	return fmt.Sprintf("%s:%s", t.name, t.arg)
+}
+
+// transformFunc transforms the value of the previous transform, or the secret value
+type transformFunc func(arg string, value interface{}) (interface{}, error)
+
+// transforms by name, piped in explicit keys like secret:config|jsonpath:$.db.password|trim and
+// references like vault:secret/data/tls#key|base64decode
+var transforms = map[string]transformFunc{
+	"base64decode": base64Decode,
+	"base64encode": base64Encode,
+	"jsonpath":     jsonPath,
+	"trim":         trim,
+}
+
+// splitTransforms splits an explicit key into the secret key and its transforms
+func splitTransforms(explicit string) (string, []transform, error) {
+	split := splitPipeline(explicit)
+	pipeline, err := parseTransforms("Explicit key: "+split[0], split[1:])
+	if err != nil {
+		return "", nil, err
+	}
+	return split[0], pipeline, nil
+}
+
+// splitPipeline splits a value on the | outside of brackets and quotes, so a jsonpath:$['a|b']
+// stage is not split
+func splitPipeline(value string) []string {
+	var split []string
+	var quote rune
+	depth, start := 0, 0
+	for i, r := range value {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'', r == '"':
+			quote = r
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case r == '|' && depth == 0:
+			split = append(split, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(split, value[start:])
+}
+
+// parseTransforms parses the transform stages piped after the subject, like an explicit key or a reference
+func parseTransforms(subject string, stages []string) ([]transform, error) {
+	var pipeline []transform
+	for _, stage := range stages {
+		nameArg := strings.SplitN(strings.TrimSpace(stage), ":", 2)
+		t := transform{name: nameArg[0]}
+		if len(nameArg) == 2 {
+			t.arg = nameArg[1]
+		}
+		if _, ok := transforms[t.name]; !ok {
+			return nil, fmt.Errorf("%s has an unknown transform %q, expected one of %s", subject, t.name, strings.Join(transformNames(), ", "))
+		}
+		pipeline = append(pipeline, t)
+	}
+	return pipeline, nil
+}
+
+// applyTransforms pipes the secret value through the transforms and returns the env var value
+func applyTransforms(subject string, value interface{}, pipeline []transform) (string, error) {
+	for _, t := range pipeline {
+		transformed, err := transforms[t.name](t.arg, value)
+		if err != nil {
+			return "", fmt.Errorf("%s transform %s failed: %v", subject, t, err)
+		}
+		value = transformed
+	}
+	return formatValue(value), nil
+}
+
+// transformNames returns the sorted transform names
+func transformNames() []string {
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func base64Decode(_ string, value interface{}) (interface{}, error) {
+	s := strings.TrimSpace(formatValue(value))
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		// unpadded values
+		if decoded, rawErr := base64.RawStdEncoding.DecodeString(s); rawErr == nil {
+			return string(decoded), nil
+		}
+		return nil, fmt.Errorf("the value is not base64 encoded: %v", err)
+	}
+	return string(decoded), nil
+}
+
+func base64Encode(_ string, value interface{}) (interface{}, error) {
+	return base64.StdEncoding.EncodeToString([]byte(formatValue(value))), nil
+}
+
+func trim(_ string, value interface{}) (interface{}, error) {
+	return strings.TrimSpace(formatValue(value)), nil
+}
+
+// jsonPath selects a value of a JSON document with a path like $.db.password, $.hosts[0] or
+// $['db.name'], string values are decoded as JSON first
+func jsonPath(path string, value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		if err := json.Unmarshal([]byte(s), &value); err != nil {
+			return nil, fmt.Errorf("the value is not JSON: %v", err)
+		}
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q does not start with $", path)
+	}
+
+	rest := path[1:]
+	for rest != "" {
+		var key string
+		index := -1
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+			if key == "" {
+				return nil, fmt.Errorf("path %q has an empty key", path)
+			}
+		case strings.HasPrefix(rest, "['"), strings.HasPrefix(rest, `["`):
+			quote := rest[1:2]
+			end := strings.Index(rest[2:], quote+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unterminated key", path)
+			}
+			key, rest = rest[2:2+end], rest[2+end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unterminated index", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("path %q has an invalid index %q", path, rest[1:end])
+			}
+			index, rest = i, rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path %q is invalid at %q", path, rest)
+		}
+
+		if index >= 0 {
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("path %q: index %d of a value that is not an array", path, index)
+			}
+			if index >= len(array) {
+				return nil, fmt.Errorf("path %q: index %d out of range, the array has %d items", path, index, len(array))
+			}
+			value = array[index]
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("path %q: key %s of a value that is not an object", path, key)
+		}
+		if value, ok = object[key]; !ok {
+			return nil, fmt.Errorf("path %q: key %s not found", path, key)
+		}
+	}
+	return value, nil
+}
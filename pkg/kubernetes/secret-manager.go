@@ -0,0 +1,248 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	"github.com/doitintl/secrets-consumer-env/pkg/vault"
+	log "github.com/sirupsen/logrus"
+)
+
+// In-cluster service account files
+const (
+	DefaultTokenPath     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultCACertPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	DefaultNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	requestTimeout = 30 * time.Second
+)
+
+// Config - configuration for Kubernetes Secrets
+type Config struct {
+	// APIServer URL, defaults to the in-cluster KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT
+	APIServer string
+	TokenPath string
+	// CACertPath defaults to the service account CA with the in-cluster API server, and to the
+	// system roots with APIServer
+	CACertPath string
+	// Namespace of the secrets without one, defaults to the service account namespace
+	Namespace string
+	// SecretNames are secret names or namespace/name references, later secrets win
+	SecretNames []string
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// SecretRef is a reference to a Secret in a namespace
+type SecretRef struct {
+	Namespace string
+	Name      string
+}
+
+// ParseSecretRef parse a name or namespace/name secret reference
+func ParseSecretRef(ref, defaultNamespace string) (SecretRef, error) {
+	split := strings.Split(ref, "/")
+	switch {
+	case len(split) == 1 && split[0] != "":
+		return SecretRef{Namespace: defaultNamespace, Name: split[0]}, nil
+	case len(split) == 2 && split[0] != "" && split[1] != "":
+		return SecretRef{Namespace: split[0], Name: split[1]}, nil
+	}
+	return SecretRef{}, fmt.Errorf("bad secret reference %q, expected <name> or <namespace>/<name>", ref)
+}
+
+// InClusterAPIServer returns the API server URL from the in-cluster service environment variables
+func InClusterAPIServer() (string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return "", errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set, pass the API server with --api-server when running outside of a cluster")
+	}
+	return "https://" + net.JoinHostPort(host, port), nil
+}
+
+// ServiceAccountNamespace returns the namespace of the pod service account
+func ServiceAccountNamespace(namespacePath string) (string, error) {
+	namespace, err := ioutil.ReadFile(namespacePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the service account namespace file %v, pass the namespace with --namespace", err)
+	}
+	return strings.TrimSpace(string(namespace)), nil
+}
+
+// Kinds of API server failures, use errors.Is to check a returned error against them
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrForbidden      = errors.New("forbidden")
+)
+
+// StatusError is a failed API server response
+type StatusError struct {
+	StatusCode int
+	Ref        SecretRef
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	hint := ""
+	if e.StatusCode == http.StatusForbidden {
+		hint = ", the service account needs a Role granting get on the secret"
+	}
+	return fmt.Sprintf("failed to get secret %s/%s, status %d: %s%s", e.Ref.Namespace, e.Ref.Name, e.StatusCode, e.Message, hint)
+}
+
+// Is reports whether the error is of the given kind
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrSecretNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden || e.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// Client reads Secrets from the API server with a service account token
+type Client struct {
+	APIServer  string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient create a new API server client with the service account token and CA certificate
+func NewClient(cfg *Config) (*Client, error) {
+	log.Info("Creating new Kubernetes API client")
+	apiServer := cfg.APIServer
+	if apiServer == "" {
+		var err error
+		apiServer, err = InClusterAPIServer()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := vault.GetServiceAccountToken(cfg.TokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	caCertPath := cfg.CACertPath
+	if caCertPath == "" && cfg.APIServer == "" {
+		// the in-cluster API server is verified with the service account CA
+		caCertPath = DefaultCACertPath
+	}
+	if caCertPath != "" {
+		caCert, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the API server CA certificate %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in the API server CA certificate file %s", caCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &Client{
+		APIServer: strings.TrimSuffix(apiServer, "/"),
+		Token:     strings.TrimSpace(string(token)),
+		HTTPClient: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+type secret struct {
+	Data map[string][]byte `json:"data"`
+}
+
+type status struct {
+	Message string `json:"message"`
+}
+
+// GetSecret returns the decoded data of the secret
+func (c *Client) GetSecret(ctx context.Context, ref SecretRef) (map[string]interface{}, error) {
+	secretURL := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", c.APIServer, ref.Namespace, ref.Name)
+	req, err := http.NewRequest(http.MethodGet, secretURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+
+	log.Debugf("Getting secret %s/%s", ref.Namespace, ref.Name)
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var s status
+		_ = json.Unmarshal(body, &s)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Ref: ref, Message: s.Message}
+	}
+
+	// the data values are base64 encoded, decoded into []byte by encoding/json
+	var s secret
+	if err := json.Unmarshal(body, &s); err != nil {
+		return nil, fmt.Errorf("bad secret %s/%s, can not decode JSON %v", ref.Namespace, ref.Name, err)
+	}
+	secretData := make(map[string]interface{}, len(s.Data))
+	for key, value := range s.Data {
+		secretData[key] = string(value)
+	}
+	return secretData, nil
+}
+
+// RetrieveSecret get the secrets from the API server and merge their keys in order
+func RetrieveSecret(client *Client, cfg *Config) (map[string]interface{}, error) {
+	ctx := context.Background()
+
+	var refs []SecretRef
+	for _, name := range cfg.SecretNames {
+		ref, err := ParseSecretRef(name, cfg.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if ref.Namespace == "" {
+			return nil, fmt.Errorf("namespace is missing for secret %s", ref.Name)
+		}
+		refs = append(refs, ref)
+	}
+
+	logger := log.WithFields(log.Fields{
+		"api_server": client.APIServer,
+		"namespace":  cfg.Namespace,
+		"secrets":    len(refs),
+	})
+	logger.Info("Getting secrets from Kubernetes")
+
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.Namespace + "/" + ref.Name
+	}
+	merger, err := merge.Fetch(names, func(i int) (map[string]interface{}, error) {
+		return client.GetSecret(ctx, refs[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merger.Result(cfg.Recorder), nil
+}
@@ -0,0 +1,16 @@
+package kv
+
+import (
+	"encoding/json"
+	"path"
+)
+
+// DecodeValue expands a JSON object value into its keys, any other value
+// is exported as is under the last segment of the key
+func DecodeValue(key string, value []byte) map[string]interface{} {
+	var object map[string]interface{}
+	if err := json.Unmarshal(value, &object); err == nil {
+		return object
+	}
+	return map[string]interface{}{path.Base(key): string(value)}
+}
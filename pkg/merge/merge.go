@@ -0,0 +1,183 @@
+package merge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Conflict is a secret key set by more than one secret config, the last source wins
+type Conflict struct {
+	Key     string
+	Sources []string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("key %s is set by %s", c.Key, strings.Join(c.Sources, ", "))
+}
+
+// Merger merges the secret data of secret configs in order, later configs win,
+// recording the keys set by more than one config
+type Merger struct {
+	Data     map[string]interface{}
+	sources  map[string][]string
+	versions map[string]string
+}
+
+// NewMerger create a new empty merger
+func NewMerger() *Merger {
+	return &Merger{
+		Data:     make(map[string]interface{}),
+		sources:  make(map[string][]string),
+		versions: make(map[string]string),
+	}
+}
+
+// SetVersion sets the version of the secret of the named source, like the version number of a
+// versioned secret
+func (m *Merger) SetVersion(source, version string) {
+	if version != "" {
+		m.versions[source] = version
+	}
+}
+
+// Versions returns the version of each key, the version of the last source setting it, the keys
+// of sources without a version are left out
+func (m *Merger) Versions() map[string]string {
+	versions := make(map[string]string)
+	for key, sources := range m.sources {
+		if version, ok := m.versions[sources[len(sources)-1]]; ok {
+			versions[key] = version
+		}
+	}
+	return versions
+}
+
+// Add merges the data of the named source
+func (m *Merger) Add(source string, data map[string]interface{}) {
+	for key, value := range data {
+		m.Data[key] = value
+		m.sources[key] = append(m.sources[key], source)
+	}
+}
+
+// Conflicts returns the keys set by more than one source, sorted by key
+func (m *Merger) Conflicts() []Conflict {
+	var conflicts []Conflict
+	for key, sources := range m.sources {
+		if len(sources) > 1 {
+			conflicts = append(conflicts, Conflict{Key: key, Sources: sources})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Key < conflicts[j].Key })
+	return conflicts
+}
+
+// DefaultFetchWorkers is the number of sources Fetch fetches at a time, so discovering hundreds of
+// secrets does not hit the API quotas
+const DefaultFetchWorkers = 10
+
+// Fetch calls fetch for every source concurrently, DefaultFetchWorkers at a time, and merges the
+// results in the sources order so later sources win, the error of the first failed source in order
+// is returned
+func Fetch(sources []string, fetch func(i int) (map[string]interface{}, error)) (*Merger, error) {
+	return FetchWithWorkers(sources, DefaultFetchWorkers, fetch)
+}
+
+// FetchWithWorkers is Fetch with at most workers sources fetched at a time, 0 or less uses
+// DefaultFetchWorkers
+func FetchWithWorkers(sources []string, workers int, fetch func(i int) (map[string]interface{}, error)) (*Merger, error) {
+	if workers <= 0 {
+		workers = DefaultFetchWorkers
+	}
+	results := make([]map[string]interface{}, len(sources))
+	errs := make([]error, len(sources))
+	semaphore := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range sources {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			results[i], errs[i] = fetch(i)
+		}(i)
+	}
+	wg.Wait()
+
+	merger := NewMerger()
+	for i, source := range sources {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		merger.Add(source, results[i])
+	}
+	return merger, nil
+}
+
+// Recorder collects the conflicts and the key versions of the merges of a run for the strict mode
+// and the check command, a nil Recorder discards them
+type Recorder struct {
+	mu        sync.Mutex
+	conflicts []Conflict
+	versions  map[string]string
+}
+
+// Record adds the conflicts to the recorder
+func (r *Recorder) Record(conflicts ...Conflict) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conflicts = append(r.conflicts, conflicts...)
+}
+
+// Conflicts returns the recorded conflicts in the order they were recorded
+func (r *Recorder) Conflicts() []Conflict {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Conflict(nil), r.conflicts...)
+}
+
+// RecordVersions adds the versions of the keys to the recorder, a later version of a key wins
+func (r *Recorder) RecordVersions(versions map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.versions == nil {
+		r.versions = make(map[string]string, len(versions))
+	}
+	for key, version := range versions {
+		r.versions[key] = version
+	}
+}
+
+// Versions returns the recorded versions by key
+func (r *Recorder) Versions() map[string]string {
+	versions := make(map[string]string)
+	if r == nil {
+		return versions
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, version := range r.versions {
+		versions[key] = version
+	}
+	return versions
+}
+
+// Result records the conflicts and the key versions in the recorder and returns the merged data
+func (m *Merger) Result(recorder *Recorder) map[string]interface{} {
+	recorder.Record(m.Conflicts()...)
+	recorder.RecordVersions(m.Versions())
+	return m.Data
+}
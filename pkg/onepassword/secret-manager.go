@@ -0,0 +1,238 @@
+package onepassword
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	log "github.com/sirupsen/logrus"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Config - configuration for 1Password Connect
+type Config struct {
+	// Host of the Connect server, like http://onepassword-connect:8080
+	Host  string
+	Token string
+	// Items are vault/item references, vaults and items by name or UUID, later items win
+	Items []string
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
+}
+
+// ItemRef is a reference to an item in a vault
+type ItemRef struct {
+	Vault string
+	Item  string
+}
+
+// ParseItemRef parse a vault/item or op://vault/item reference
+func ParseItemRef(ref string) (ItemRef, error) {
+	split := strings.SplitN(strings.TrimPrefix(ref, "op://"), "/", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return ItemRef{}, fmt.Errorf("bad item reference %q, expected <vault>/<item>", ref)
+	}
+	return ItemRef{Vault: split[0], Item: split[1]}, nil
+}
+
+// Kinds of Connect failures, use errors.Is to check a returned error against them
+var (
+	ErrVaultNotFound = errors.New("vault not found")
+	ErrItemNotFound  = errors.New("item not found")
+	ErrUnauthorized  = errors.New("unauthorized")
+)
+
+// uuidPattern matches the 26 characters 1Password UUIDs
+var uuidPattern = regexp.MustCompile(`^[a-z0-9]{26}$`)
+
+var invalidEnvNameChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// EnvName turns a field label into an env var name, so "db password" becomes DB_PASSWORD
+func EnvName(label string) string {
+	return invalidEnvNameChars.ReplaceAllString(strings.ToUpper(strings.TrimSpace(label)), "_")
+}
+
+// Client reads items from the 1Password Connect REST API
+type Client struct {
+	Host       string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient create a new 1Password Connect client with the access token
+func NewClient(cfg *Config) (*Client, error) {
+	log.Info("Creating new 1Password Connect client")
+	if cfg.Host == "" {
+		return nil, errors.New("1Password Connect host is missing")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("1Password Connect token is missing")
+	}
+	return &Client{
+		Host:       strings.TrimSuffix(cfg.Host, "/"),
+		Token:      cfg.Token,
+		HTTPClient: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+type vault struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type itemSummary struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// Field is an item field
+type Field struct {
+	ID      string `json:"id"`
+	Label   string `json:"label"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose"`
+	Value   string `json:"value"`
+}
+
+// Item is a vault item with its fields
+type Item struct {
+	ID     string  `json:"id"`
+	Title  string  `json:"title"`
+	Fields []Field `json:"fields"`
+}
+
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (c *Client) get(apiPath string, query url.Values, v interface{}) error {
+	apiURL := c.Host + apiPath
+	if len(query) > 0 {
+		apiURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		_ = json.Unmarshal(body, &errResp)
+		if errResp.Message == "" {
+			errResp.Message = strings.TrimSpace(string(body))
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("%w, status %d: %s", ErrUnauthorized, resp.StatusCode, errResp.Message)
+		}
+		return fmt.Errorf("status %d: %s", resp.StatusCode, errResp.Message)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("can not decode JSON %v", err)
+	}
+	return nil
+}
+
+// vaultID resolves a vault name to its UUID
+func (c *Client) vaultID(name string) (string, error) {
+	if uuidPattern.MatchString(name) {
+		return name, nil
+	}
+	var vaults []vault
+	if err := c.get("/v1/vaults", url.Values{"filter": {fmt.Sprintf("name eq %q", name)}}, &vaults); err != nil {
+		return "", fmt.Errorf("failed to find vault %s: %w", name, err)
+	}
+	if len(vaults) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrVaultNotFound, name)
+	}
+	return vaults[0].ID, nil
+}
+
+// itemID resolves an item title to its UUID
+func (c *Client) itemID(vaultID, title string) (string, error) {
+	if uuidPattern.MatchString(title) {
+		return title, nil
+	}
+	var items []itemSummary
+	err := c.get(fmt.Sprintf("/v1/vaults/%s/items", vaultID), url.Values{"filter": {fmt.Sprintf("title eq %q", title)}}, &items)
+	if err != nil {
+		return "", fmt.Errorf("failed to find item %s: %w", title, err)
+	}
+	switch len(items) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", ErrItemNotFound, title)
+	case 1:
+		return items[0].ID, nil
+	}
+	return "", fmt.Errorf("%d items are titled %s, reference the item by UUID", len(items), title)
+}
+
+// GetItem returns the item with its fields
+func (c *Client) GetItem(ref ItemRef) (*Item, error) {
+	log.Debugf("Getting 1Password item %s/%s", ref.Vault, ref.Item)
+	vaultID, err := c.vaultID(ref.Vault)
+	if err != nil {
+		return nil, err
+	}
+	itemID, err := c.itemID(vaultID, ref.Item)
+	if err != nil {
+		return nil, err
+	}
+	var item Item
+	if err := c.get(fmt.Sprintf("/v1/vaults/%s/items/%s", vaultID, itemID), nil, &item); err != nil {
+		return nil, fmt.Errorf("failed to get item %s/%s: %w", ref.Vault, ref.Item, err)
+	}
+	return &item, nil
+}
+
+// RetrieveSecret get the items from 1Password Connect and map their fields to env vars,
+// a field is exported under its label, later items win
+func RetrieveSecret(client *Client, cfg *Config) (map[string]interface{}, error) {
+	logger := log.WithFields(log.Fields{
+		"host":  client.Host,
+		"items": cfg.Items,
+	})
+	logger.Info("Getting secrets from 1Password Connect")
+
+	merger := merge.NewMerger()
+	for _, ref := range cfg.Items {
+		itemRef, err := ParseItemRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		item, err := client.GetItem(itemRef)
+		if err != nil {
+			return nil, err
+		}
+		itemData := make(map[string]interface{})
+		for _, field := range item.Fields {
+			// empty fields, like an unset one time password, are skipped
+			if field.Value == "" || field.Label == "" {
+				continue
+			}
+			itemData[EnvName(field.Label)] = field.Value
+		}
+		merger.Add(ref, itemData)
+	}
+	return merger.Result(cfg.Recorder), nil
+}
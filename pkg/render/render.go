@@ -0,0 +1,160 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats
+const (
+	FormatDotenv = "dotenv"
+	FormatJSON   = "json"
+	FormatYAML   = "yaml"
+	FormatExport = "export"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{FormatDotenv, FormatJSON, FormatYAML, FormatExport}
+
+// FileMode is the permission of the rendered files, they hold secrets
+const FileMode os.FileMode = 0600
+
+// Variable is an env var name and value
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// ParseEnviron splits name=value entries into variables sorted by name, later entries win
+func ParseEnviron(environ []string) []Variable {
+	values := make(map[string]string, len(environ))
+	for _, env := range environ {
+		split := strings.SplitN(env, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+		values[split[0]] = split[1]
+	}
+	variables := make([]Variable, 0, len(values))
+	for name, value := range values {
+		variables = append(variables, Variable{Name: name, Value: value})
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+	return variables
+}
+
+// plainValue matches the dotenv values written without quotes
+var plainValue = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,-]+$`)
+
+// dotenvQuote double-quotes a value with backslash escapes, newlines are written as \n
+// so multi-line values, like PEM keys, stay on one line
+func dotenvQuote(value string) string {
+	if plainValue.MatchString(value) {
+		return value
+	}
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"$", `\$`,
+		"`", "\\`",
+		"\n", `\n`,
+		"\r", `\r`,
+	)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// shellQuote single-quotes a value for POSIX shells, nothing is expanded inside single quotes
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// Render writes the variables in the format
+func Render(w io.Writer, format string, variables []Variable) error {
+	switch format {
+	case FormatDotenv, "":
+		for _, v := range variables {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", v.Name, dotenvQuote(v.Value)); err != nil {
+				return err
+			}
+		}
+	case FormatExport:
+		for _, v := range variables {
+			if _, err := fmt.Fprintf(w, "export %s=%s\n", v.Name, shellQuote(v.Value)); err != nil {
+				return err
+			}
+		}
+	case FormatJSON:
+		object := make(map[string]string, len(variables))
+		for _, v := range variables {
+			object[v.Name] = v.Value
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(object)
+	case FormatYAML:
+		object := make(yaml.MapSlice, 0, len(variables))
+		for _, v := range variables {
+			object = append(object, yaml.MapItem{Key: v.Name, Value: v.Value})
+		}
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+	return nil
+}
+
+// WriteFile renders the variables into the file atomically, the data is written to a temporary
+// file with FileMode permissions in the same directory and renamed over the path,
+// an empty path or "-" writes to stdout
+func WriteFile(path, format string, variables []Variable) error {
+	var buf bytes.Buffer
+	if err := Render(&buf, format, variables); err != nil {
+		return err
+	}
+	if path == "" || path == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary file for %s: %v", path, err)
+	}
+	// the temporary file is removed unless renamed
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(FileMode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set the permissions of %s: %v", tmp.Name(), err)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %v", tmp.Name(), path, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config holds the file paths of the CA certificate and the client certificate and key
+type Config struct {
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables the server certificate verification
+	InsecureSkipVerify bool
+}
+
+// IsSet reports whether any TLS setting is configured
+func (cfg *Config) IsSet() bool {
+	return cfg.CACert != "" || cfg.ClientCert != "" || cfg.ClientKey != "" || cfg.InsecureSkipVerify
+}
+
+// New creates a TLS client config trusting the CA certificate (or the system roots without one)
+// and presenting the client certificate for mutual TLS
+func New(cfg *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CACert != "" {
+		caCert, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA certificate %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in the CA certificate file %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, fmt.Errorf("both the client certificate and key are needed for TLS client authentication")
+		}
+		certificate, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
@@ -1,16 +1,33 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/compute/metadata"
+	"github.com/doitintl/secrets-consumer-env/pkg/gcp"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"golang.org/x/oauth2/jwt"
-	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+)
+
+// GCP backend login types
+const (
+	GCPLoginTypeIAM = "iam"
+	GCPLoginTypeGCE = "gce"
+)
+
+const (
+	// DefaultGCPMountPath is the path the GCP auth method is enabled at
+	DefaultGCPMountPath = "gcp"
+	// DefaultGCPJWTExpiry is the expiry of the iam login JWT, Vault rejects JWTs
+	// expiring after the role max_jwt_exp (15 minutes by default)
+	DefaultGCPJWTExpiry = 10 * time.Minute
 )
 
 // GCPBackendConfig parmaters for GCP backend login through Vault
@@ -18,80 +35,140 @@ type GCPBackendConfig struct {
 	Project        string
 	CredsPath      string
 	ServiceAccount string
+	// LoginType is GCPLoginTypeIAM (default) to sign a JWT with a service account key,
+	// or GCPLoginTypeGCE to use the identity token of the instance metadata server
+	LoginType string
+	// MountPath of the GCP auth method, defaults to DefaultGCPMountPath
+	MountPath string
+	// ImpersonateServiceAccount is the service account to sign the iam login JWT as,
+	// through the ImpersonateDelegates chain of service accounts
+	ImpersonateServiceAccount string
+	ImpersonateDelegates      []string
+	// JWTExpiry of the iam login JWT, defaults to DefaultGCPJWTExpiry
+	JWTExpiry time.Duration
+}
+
+// LoginPath returns the login path of the GCP auth method mount
+func (cfg *GCPBackendConfig) LoginPath() string {
+	mountPath := strings.Trim(strings.TrimPrefix(strings.Trim(cfg.MountPath, "/"), "auth/"), "/")
+	if mountPath == "" {
+		mountPath = DefaultGCPMountPath
+	}
+	return fmt.Sprintf("auth/%s/login", mountPath)
 }
 
-// GetServiceAccountCreds read the service account json
-func GetServiceAccountCreds(cfg *GCPBackendConfig) (*jwt.Config, error) {
+// GetServiceAccountCreds read the service account json, or the Application Default Credentials without one
+func GetServiceAccountCreds(ctx context.Context, cfg *GCPBackendConfig) (*google.Credentials, error) {
+	if cfg.CredsPath == "" {
+		log.Info("Getting Application Default Credentials...")
+		return gcp.FindCredentials(ctx)
+	}
+
 	log.Info("Getting service account credential file...")
 	jsonBytes, err := ioutil.ReadFile(cfg.CredsPath)
 	if err != nil {
-		log.Fatalf("error reading credentials file %v", err)
+		return nil, fmt.Errorf("error reading credentials file %v", err)
 	}
-	config, err := google.JWTConfigFromJSON(jsonBytes, iam.CloudPlatformScope)
+	creds, err := google.CredentialsFromJSON(ctx, jsonBytes, gcp.CloudPlatformScope)
 	if err != nil {
-		return nil, fmt.Errorf("error getting JWT config from JSON %v", err)
+		return nil, fmt.Errorf("error getting credentials from JSON %v", err)
+	}
+	return creds, nil
+}
+
+func (cfg *GCPBackendConfig) jwtExpiry() time.Duration {
+	if cfg.JWTExpiry <= 0 {
+		return DefaultGCPJWTExpiry
 	}
-	return config, nil
+	return cfg.JWTExpiry
 }
 
-func generateSignedJWTWithIAM(iamClient *iam.Service, cfg *GCPBackendConfig, role string) (*iam.SignJwtResponse, error) {
-	resourceName := fmt.Sprintf("projects/%s/serviceAccounts/%s", cfg.Project, cfg.ServiceAccount)
-	log.Debugf("Generating signed JWT with IAM for resource %s", resourceName)
+func generateSignedJWTWithIAM(ctx context.Context, creds *google.Credentials, cfg *GCPBackendConfig, role string) (string, error) {
+	log.Debugf("Generating signed JWT with IAM Credentials for service account %s", cfg.ServiceAccount)
 	jwtPayload := map[string]interface{}{
 		"sub": cfg.ServiceAccount,
 		"aud": fmt.Sprintf("vault/%s", role),
-		"exp": time.Now().Add(time.Minute * 10).Unix(),
+		"exp": time.Now().Add(cfg.jwtExpiry()).Unix(),
 	}
 
 	payloadBytes, err := json.Marshal(jwtPayload)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding JSON payload from vault gcp login %v", err)
+		return "", fmt.Errorf("error decoding JSON payload from vault gcp login %v", err)
 	}
 
-	signJwtReq := &iam.SignJwtRequest{
-		Payload: string(payloadBytes),
+	return gcp.SignJWT(ctx, cfg.ServiceAccount, cfg.ImpersonateDelegates, string(payloadBytes), option.WithCredentials(creds))
+}
+
+// GetGCEIdentityToken fetch the instance identity token with the vault/<role> audience from the metadata server,
+// the full format includes the instance details Vault checks against the role bound zones and labels
+func GetGCEIdentityToken(role string) (string, error) {
+	log.Info("Getting instance identity token from the metadata server...")
+	query := url.Values{}
+	query.Set("audience", fmt.Sprintf("vault/%s", role))
+	query.Set("format", "full")
+	token, err := metadata.Get("instance/service-accounts/default/identity?" + query.Encode())
+	if err != nil {
+		return "", fmt.Errorf("error getting identity token from the metadata server, the gce login type only works on GCE instances %v", err)
 	}
+	return token, nil
+}
 
-	resp, err := iamClient.Projects.ServiceAccounts.SignJwt(resourceName, signJwtReq).Do()
+func getIAMSignedJWT(gcpBackendConfig *GCPBackendConfig, role string) (string, error) {
+	ctx := context.Background()
+	creds, err := GetServiceAccountCreds(ctx, gcpBackendConfig)
 	if err != nil {
-		return nil, fmt.Errorf("error siging JWT using GCP IAM %v", err)
+		return "", err
+	}
+
+	// the JWT is signed by and for the impersonated service account, or the caller itself
+	gcpBackendConfig.ServiceAccount = gcpBackendConfig.ImpersonateServiceAccount
+	if gcpBackendConfig.ServiceAccount == "" {
+		gcpBackendConfig.ServiceAccount = gcp.CredentialsEmail(creds)
+	}
+	if !strings.Contains(gcpBackendConfig.ServiceAccount, "@") {
+		return "", fmt.Errorf("the iam login type needs service account credentials, got %s, use --impersonate-service-account", gcpBackendConfig.ServiceAccount)
 	}
-	return resp, nil
+	return generateSignedJWTWithIAM(ctx, creds, gcpBackendConfig, role)
 }
 
 // GCPBackendLogin Authenticate to Vault via GCP Backend
 func GCPBackendLogin(client *Client, gcpBackendConfig *GCPBackendConfig, vaultConfig *Config) (string, error) {
-	var logger *log.Entry
+	var (
+		logger *log.Entry
+		jwt    string
+		err    error
+	)
 
-	config, err := GetServiceAccountCreds(gcpBackendConfig)
-	if err != nil {
-		return "", err
+	switch gcpBackendConfig.LoginType {
+	case GCPLoginTypeGCE:
+		jwt, err = GetGCEIdentityToken(vaultConfig.Role)
+	case GCPLoginTypeIAM, "":
+		jwt, err = getIAMSignedJWT(gcpBackendConfig, vaultConfig.Role)
+	default:
+		err = fmt.Errorf("unknown GCP login type %q, expected %s or %s", gcpBackendConfig.LoginType, GCPLoginTypeIAM, GCPLoginTypeGCE)
 	}
-	gcpBackendConfig.ServiceAccount = config.Email
-	httpClient := config.Client(oauth2.NoContext)
-	iamClient, err := iam.New(httpClient)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := generateSignedJWTWithIAM(iamClient, gcpBackendConfig, vaultConfig.Role)
-	if err != nil {
-		return "", err
-	}
 	// Send signed JWT in login request to Vault.
 	params := map[string]interface{}{
 		"role": vaultConfig.Role,
-		"jwt":  resp.SignedJwt,
+		"jwt":  jwt,
 	}
 	logger = log.WithFields(log.Fields{
 		"project":        gcpBackendConfig.Project,
 		"serviceAccount": gcpBackendConfig.ServiceAccount,
+		"loginType":      gcpBackendConfig.LoginType,
+		"loginPath":      gcpBackendConfig.LoginPath(),
 	})
 	logger.Infof("Login into Vault GCP backend using the role %s", vaultConfig.Role)
-	secretData, err := client.Logical.Write("auth/gcp/login", params)
+	secretData, err := client.Logical.Write(gcpBackendConfig.LoginPath(), params)
 	if err != nil {
 		return "", fmt.Errorf("failed login to Vault using GCP backend %v", err)
 	}
-	clientToken := &secretData.Auth.ClientToken
-	return *clientToken, nil
+	if secretData == nil || secretData.Auth == nil {
+		return "", fmt.Errorf("no auth info returned from Vault GCP backend login at %s", gcpBackendConfig.LoginPath())
+	}
+	return secretData.Auth.ClientToken, nil
 }
@@ -0,0 +1,19 @@
+package vault
+
+import (
+	"github.com/hashicorp/vault/api"
+)
+
+// ReadPaths reads each secret API path once, like secret/data/db for KV v2,
+// and returns the secret data of each path with the KV v2 data unwrapped
+func ReadPaths(client *api.Client, paths []string) (map[string]interface{}, error) {
+	secrets := make(map[string]interface{}, len(paths))
+	for _, secretPath := range paths {
+		secret, err := readSecret(client, sanitizePath(secretPath))
+		if err != nil {
+			return nil, err
+		}
+		secrets[secretPath] = CastSecretDataToStringMap(secret.Data)
+	}
+	return secrets, nil
+}
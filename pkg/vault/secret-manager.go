@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
 	"github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
@@ -30,6 +31,8 @@ type Config struct {
 	Backend           string
 	KubernetesBackend string
 	SecretsConfigList []SecretConfig
+	// Recorder records the keys set by more than one secret and the key versions, when set
+	Recorder *merge.Recorder
 }
 
 // SecretConfigJSON JSON struct for secret config
@@ -54,6 +57,15 @@ func CastSecretDataToStringMap(secretData map[string]interface{}) map[string]int
 	return data
 }
 
+// secretVersion returns the KV v2 metadata version of the secret data, empty without one
+func secretVersion(secretData map[string]interface{}) string {
+	metadata, ok := secretData["metadata"].(map[string]interface{})
+	if !ok || metadata["version"] == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", metadata["version"])
+}
+
 // ConfigureVaultSecrets configure Vault Role TokenPath Backend and SecretsConfigList
 func ConfigureVaultSecrets(client *api.Client, secretConfigs []string, vaultCfg *Config) (*Config, error) {
 	/*
@@ -323,7 +335,7 @@ func RetrieveSecret(client *api.Client, cfg *SecretConfig) (map[string]interface
 
 // RetrieveSecrets iterate over secretConfigsList and retrieve each secret
 func RetrieveSecrets(client *api.Client, vaultCfg *Config) (map[string]interface{}, error) {
-	secretData := make(map[string]interface{})
+	merger := merge.NewMerger()
 	var err error
 
 	for _, secretConfig := range vaultCfg.SecretsConfigList {
@@ -333,11 +345,9 @@ func RetrieveSecrets(client *api.Client, vaultCfg *Config) (map[string]interface
 			return nil, fmt.Errorf("Error getting secrets from vault: %v", err)
 		}
 
-		data := CastSecretDataToStringMap(secretConfigData)
-		for k, v := range data {
-			secretData[k] = v
-		}
+		merger.Add(secretConfig.Path, CastSecretDataToStringMap(secretConfigData))
+		merger.SetVersion(secretConfig.Path, secretVersion(secretConfigData))
 	}
 
-	return secretData, nil
+	return merger.Result(vaultCfg.Recorder), nil
 }
@@ -0,0 +1,649 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsSecretsManager "github.com/doitintl/secrets-consumer-env/pkg/aws"
+	"github.com/google/go-cmp/cmp"
+)
+
+const assumedRoleAccessKeyID = "ASIASTANDINASSUMED"
+
+type fakeAWSSecret struct {
+	current  string
+	previous string
+	tags     map[string]string
+	// errors are returned in order before the secret value, alwaysError on every request
+	errors      []string
+	alwaysError string
+}
+
+// fakeAWSSecretsManager is an httptest based stand-in for the Secrets Manager JSON API
+// and the STS AssumeRole query API
+type fakeAWSSecretsManager struct {
+	mu        sync.Mutex
+	secrets   map[string]fakeAWSSecret
+	requests  []string
+	accessKey string
+	attempts  map[string]int
+}
+
+func newFakeAWSSecretsManager(t *testing.T, secrets map[string]fakeAWSSecret) (*fakeAWSSecretsManager, *httptest.Server) {
+	t.Helper()
+	fake := &fakeAWSSecretsManager{secrets: secrets, attempts: map[string]int{}}
+	server := httptest.NewServer(fake)
+	return fake, server
+}
+
+func (f *fakeAWSSecretsManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	f.accessKey = accessKeyFromAuthorization(r.Header.Get("Authorization"))
+
+	target := r.Header.Get("X-Amz-Target")
+	if target == "" && strings.Contains(string(body), "Action=AssumeRole") {
+		f.requests = append(f.requests, "sts.AssumeRole")
+		f.assumeRole(w)
+		return
+	}
+	f.requests = append(f.requests, target)
+
+	var input map[string]interface{}
+	if err := json.Unmarshal(body, &input); err != nil {
+		writeAWSError(w, http.StatusBadRequest, "InvalidRequestException", err.Error())
+		return
+	}
+
+	switch target {
+	case "secretsmanager.GetSecretValue":
+		f.getSecretValue(w, input)
+	case "secretsmanager.ListSecrets":
+		f.listSecrets(w, input)
+	default:
+		writeAWSError(w, http.StatusBadRequest, "UnknownOperationException", target)
+	}
+}
+
+func (f *fakeAWSSecretsManager) getSecretValue(w http.ResponseWriter, input map[string]interface{}) {
+	name, _ := input["SecretId"].(string)
+	stage, _ := input["VersionStage"].(string)
+	attempt := f.attempts[name]
+	f.attempts[name]++
+	secret, ok := f.secrets[name]
+	if !ok {
+		writeAWSError(w, http.StatusBadRequest, "ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+		return
+	}
+
+	errCode := secret.alwaysError
+	if attempt < len(secret.errors) {
+		errCode = secret.errors[attempt]
+	}
+	if errCode != "" {
+		status := http.StatusBadRequest
+		if errCode == "InternalServiceError" {
+			status = http.StatusInternalServerError
+		}
+		writeAWSError(w, status, errCode, "stand-in error")
+		return
+	}
+
+	value := secret.current
+	if stage == "AWSPREVIOUS" {
+		value = secret.previous
+	}
+	writeAWSJSON(w, map[string]interface{}{
+		"ARN":           fmt.Sprintf("arn:aws:secretsmanager:us-east-1:123456789012:secret:%s", name),
+		"Name":          name,
+		"SecretString":  value,
+		"VersionId":     "00000000-0000-0000-0000-000000000000",
+		"VersionStages": []string{stage},
+	})
+}
+
+// listSecrets applies the name (prefix), tag-key and tag-value filters and returns pages of two secrets
+func (f *fakeAWSSecretsManager) listSecrets(w http.ResponseWriter, input map[string]interface{}) {
+	var names []string
+	for name := range f.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filters, _ := input["Filters"].([]interface{})
+	var matched []string
+	for _, name := range names {
+		if matchesAWSFilters(name, f.secrets[name].tags, filters) {
+			matched = append(matched, name)
+		}
+	}
+
+	const pageSize = 2
+	start := 0
+	if token, ok := input["NextToken"].(string); ok {
+		start, _ = strconv.Atoi(token)
+	}
+	end := start + pageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	var secretList []map[string]interface{}
+	for _, name := range matched[start:end] {
+		var tags []map[string]string
+		for key, value := range f.secrets[name].tags {
+			tags = append(tags, map[string]string{"Key": key, "Value": value})
+		}
+		secretList = append(secretList, map[string]interface{}{"Name": name, "Tags": tags})
+	}
+	output := map[string]interface{}{"SecretList": secretList}
+	if end < len(matched) {
+		output["NextToken"] = strconv.Itoa(end)
+	}
+	writeAWSJSON(w, output)
+}
+
+func matchesAWSFilters(name string, tags map[string]string, filters []interface{}) bool {
+	for _, raw := range filters {
+		filter, _ := raw.(map[string]interface{})
+		values, _ := filter["Values"].([]interface{})
+		matched := false
+		for _, v := range values {
+			value, _ := v.(string)
+			switch filter["Key"] {
+			case "name":
+				matched = matched || strings.HasPrefix(name, value)
+			case "tag-key":
+				_, ok := tags[value]
+				matched = matched || ok
+			case "tag-value":
+				for _, tagValue := range tags {
+					matched = matched || tagValue == value
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeAWSSecretsManager) assumeRole(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>%s</AccessKeyId>
+      <SecretAccessKey>stand-in-secret</SecretAccessKey>
+      <SessionToken>stand-in-token</SessionToken>
+      <Expiration>2100-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/reader/session</Arn>
+      <AssumedRoleId>AROASTANDIN:session</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>stand-in</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, assumedRoleAccessKeyID)
+}
+
+func writeAWSJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAWSError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"__type": errType, "message": message})
+}
+
+// accessKeyFromAuthorization extracts the access key id from a SigV4 Authorization header
+func accessKeyFromAuthorization(header string) string {
+	i := strings.Index(header, "Credential=")
+	if i < 0 {
+		return ""
+	}
+	credential := header[i+len("Credential="):]
+	return strings.SplitN(credential, "/", 2)[0]
+}
+
+// setStaticAWSCredentials keeps the SDK credential chain away from the instance metadata service
+func setStaticAWSCredentials(t *testing.T) {
+	t.Helper()
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIASTANDIN",
+		"AWS_SECRET_ACCESS_KEY": "stand-in-secret",
+	}
+	for name, value := range env {
+		previous, ok := os.LookupEnv(name)
+		os.Setenv(name, value)
+		name := name
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestAWSRetrieveSecretCustomEndpoint(t *testing.T) {
+	setStaticAWSCredentials(t)
+	fake, server := newFakeAWSSecretsManager(t, map[string]fakeAWSSecret{
+		"prod/payments/api": {
+			current:  `{"API_KEY": "new123def", "DB_PORT": 5432}`,
+			previous: `{"API_KEY": "old123abc", "DB_PORT": 5432}`,
+		},
+	})
+	defer server.Close()
+
+	testCases := []struct {
+		name            string
+		cfg             *awsSecretsManager.Config
+		wants           map[string]interface{}
+		wantsRequests   []string
+		wantsAccessKey  string
+		wantsErrMessage string
+	}{
+		{
+			name: "current version",
+			cfg: &awsSecretsManager.Config{
+				Region:      "us-east-1",
+				SecretName:  aws.String("prod/payments/api"),
+				EndpointURL: server.URL,
+			},
+			wants:          map[string]interface{}{"API_KEY": "new123def", "DB_PORT": float64(5432)},
+			wantsRequests:  []string{"secretsmanager.GetSecretValue"},
+			wantsAccessKey: "AKIASTANDIN",
+		},
+		{
+			name: "previous version",
+			cfg: &awsSecretsManager.Config{
+				Region:          "eu-west-1",
+				SecretName:      aws.String("prod/payments/api"),
+				PreviousVersion: "true",
+				EndpointURL:     server.URL,
+			},
+			wants:          map[string]interface{}{"API_KEY": "old123abc", "DB_PORT": float64(5432)},
+			wantsRequests:  []string{"secretsmanager.GetSecretValue"},
+			wantsAccessKey: "AKIASTANDIN",
+		},
+		{
+			name: "assume role through custom STS endpoint",
+			cfg: &awsSecretsManager.Config{
+				Region:      "us-east-1",
+				SecretName:  aws.String("prod/payments/api"),
+				RoleARN:     "arn:aws:iam::123456789012:role/reader",
+				EndpointURL: server.URL,
+				STSEndpoint: server.URL,
+			},
+			wants:          map[string]interface{}{"API_KEY": "new123def", "DB_PORT": float64(5432)},
+			wantsRequests:  []string{"sts.AssumeRole", "secretsmanager.GetSecretValue"},
+			wantsAccessKey: assumedRoleAccessKeyID,
+		},
+		{
+			name: "missing secret",
+			cfg: &awsSecretsManager.Config{
+				Region:      "us-east-1",
+				SecretName:  aws.String("prod/payments/missing"),
+				EndpointURL: server.URL,
+			},
+			wantsRequests:   []string{"secretsmanager.GetSecretValue"},
+			wantsErrMessage: "ResourceNotFoundException",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			fake.requests = nil
+			secretData, err := awsSecretsManager.RetrieveSecret(testCase.cfg)
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+			} else if err != nil {
+				t.Fatalf("error retrieving secret data: %v", err)
+			}
+
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+			if !cmp.Equal(fake.requests, testCase.wantsRequests) {
+				t.Errorf("requests = diff %v", cmp.Diff(fake.requests, testCase.wantsRequests))
+			}
+			if testCase.wantsAccessKey != "" && fake.accessKey != testCase.wantsAccessKey {
+				t.Errorf("request signed with access key %s, wants %s", fake.accessKey, testCase.wantsAccessKey)
+			}
+		})
+	}
+}
+
+func TestAWSEndpointResolver(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cfg     *awsSecretsManager.Config
+		service string
+		region  string
+		wants   string
+	}{
+		{
+			name:   "default endpoint",
+			cfg:    &awsSecretsManager.Config{},
+			region: "us-east-1",
+			wants:  "https://secretsmanager.us-east-1.amazonaws.com",
+		},
+		{
+			name:   "custom endpoint",
+			cfg:    &awsSecretsManager.Config{EndpointURL: "https://vpce-0123-abcd.secretsmanager.us-east-1.vpce.amazonaws.com"},
+			region: "us-east-1",
+			wants:  "https://vpce-0123-abcd.secretsmanager.us-east-1.vpce.amazonaws.com",
+		},
+		{
+			name:   "fips endpoint",
+			cfg:    &awsSecretsManager.Config{UseFIPSEndpoint: true},
+			region: "us-west-2",
+			wants:  "https://secretsmanager-fips.us-west-2.amazonaws.com",
+		},
+		{
+			name:   "dual-stack endpoint",
+			cfg:    &awsSecretsManager.Config{UseDualStackEndpoint: true},
+			region: "eu-central-1",
+			wants:  "https://secretsmanager.eu-central-1.api.aws",
+		},
+		{
+			name:   "fips dual-stack endpoint",
+			cfg:    &awsSecretsManager.Config{UseFIPSEndpoint: true, UseDualStackEndpoint: true},
+			region: "us-east-2",
+			wants:  "https://secretsmanager-fips.us-east-2.api.aws",
+		},
+		{
+			name:   "china dual-stack endpoint",
+			cfg:    &awsSecretsManager.Config{UseDualStackEndpoint: true},
+			region: "cn-north-1",
+			wants:  "https://secretsmanager.cn-north-1.api.amazonwebservices.com.cn",
+		},
+		{
+			name:   "govcloud fips endpoint",
+			cfg:    &awsSecretsManager.Config{UseFIPSEndpoint: true},
+			region: "us-gov-west-1",
+			wants:  "https://secretsmanager-fips.us-gov-west-1.amazonaws.com",
+		},
+		{
+			name:    "sts fips endpoint",
+			cfg:     &awsSecretsManager.Config{UseFIPSEndpoint: true},
+			service: "sts",
+			region:  "us-east-1",
+			wants:   "https://sts-fips.us-east-1.amazonaws.com",
+		},
+		{
+			name:    "govcloud sts fips endpoint",
+			cfg:     &awsSecretsManager.Config{UseFIPSEndpoint: true},
+			service: "sts",
+			region:  "us-gov-west-1",
+			wants:   "https://sts.us-gov-west-1.amazonaws.com",
+		},
+		{
+			name:    "custom sts endpoint wins over fips",
+			cfg:     &awsSecretsManager.Config{STSEndpoint: "http://localhost:4566", UseFIPSEndpoint: true},
+			service: "sts",
+			region:  "us-east-1",
+			wants:   "http://localhost:4566",
+		},
+		{
+			name:   "custom endpoint wins over fips",
+			cfg:    &awsSecretsManager.Config{EndpointURL: "http://localhost:4566", UseFIPSEndpoint: true},
+			region: "us-east-1",
+			wants:  "http://localhost:4566",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			service := testCase.service
+			if service == "" {
+				service = "secretsmanager"
+			}
+			resolver := awsSecretsManager.NewEndpointResolver(testCase.cfg)
+			endpoint, err := resolver.EndpointFor(service, testCase.region)
+			if err != nil {
+				t.Fatalf("error resolving endpoint: %v", err)
+			}
+			if endpoint.URL != testCase.wants {
+				t.Errorf("endpoint = %s, wants %s", endpoint.URL, testCase.wants)
+			}
+		})
+	}
+}
+
+func TestAWSDiscoverSecrets(t *testing.T) {
+	setStaticAWSCredentials(t)
+	fake, server := newFakeAWSSecretsManager(t, map[string]fakeAWSSecret{
+		"prod/payments/api": {
+			current: `{"API_KEY": "payments-key"}`,
+			tags:    map[string]string{"service": "payments", "env": "prod"},
+		},
+		"prod/payments/db": {
+			current: `{"DB_USER": "payments", "DB_PASSWORD": "s3cr3t"}`,
+			tags:    map[string]string{"service": "payments", "env": "prod"},
+		},
+		"prod/payments/STRIPE_KEY": {
+			current: `sk_live_123`,
+			tags:    map[string]string{"service": "billing", "env": "prod"},
+		},
+		"prod/checkout/api": {
+			current: `{"CHECKOUT_KEY": "checkout-key"}`,
+			tags:    map[string]string{"service": "checkout", "env": "prod"},
+		},
+		"staging/payments/api": {
+			current: `{"API_KEY": "staging-key"}`,
+			// tag-key env and tag-value payments both match but not as a pair
+			tags: map[string]string{"service": "staging", "env": "payments"},
+		},
+	})
+	defer server.Close()
+
+	testCases := []struct {
+		name            string
+		cfg             *awsSecretsManager.Config
+		wants           map[string]interface{}
+		wantsErrMessage string
+	}{
+		{
+			name: "name prefix",
+			cfg: &awsSecretsManager.Config{
+				NamePrefix: "prod/payments/api",
+			},
+			wants: map[string]interface{}{"API_KEY": "payments-key"},
+		},
+		{
+			name: "tags",
+			cfg: &awsSecretsManager.Config{
+				Tags: map[string]string{"service": "payments"},
+			},
+			wants: map[string]interface{}{
+				"API_KEY":     "payments-key",
+				"DB_USER":     "payments",
+				"DB_PASSWORD": "s3cr3t",
+			},
+		},
+		{
+			name: "tags are matched as key=value pairs",
+			cfg: &awsSecretsManager.Config{
+				Tags: map[string]string{"env": "payments"},
+			},
+			wants: map[string]interface{}{"API_KEY": "staging-key"},
+		},
+		{
+			name: "name prefix and tags with names as keys",
+			cfg: &awsSecretsManager.Config{
+				NamePrefix:           "prod/payments/",
+				Tags:                 map[string]string{"service": "billing"},
+				UseSecretNamesAsKeys: true,
+			},
+			wants: map[string]interface{}{"STRIPE_KEY": "sk_live_123"},
+		},
+		{
+			name: "no matching secrets",
+			cfg: &awsSecretsManager.Config{
+				NamePrefix: "dev/",
+			},
+			wantsErrMessage: "could not find secrets",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.cfg.Region = "us-east-1"
+			testCase.cfg.EndpointURL = server.URL
+			fake.requests = nil
+
+			secretData, err := awsSecretsManager.RetrieveSecret(testCase.cfg)
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error discovering secrets: %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestAWSParseTags(t *testing.T) {
+	tags, err := awsSecretsManager.ParseTags([]string{"service=payments", "team=a=b"})
+	if err != nil {
+		t.Fatalf("error parsing tags: %v", err)
+	}
+	wants := map[string]string{"service": "payments", "team": "a=b"}
+	if !cmp.Equal(tags, wants) {
+		t.Errorf("tags = diff %v", cmp.Diff(tags, wants))
+	}
+
+	if _, err := awsSecretsManager.ParseTags([]string{"service"}); err == nil {
+		t.Errorf("expected an error for a tag without a value")
+	}
+}
+
+func TestAWSErrorsAndRetries(t *testing.T) {
+	setStaticAWSCredentials(t)
+	fake, server := newFakeAWSSecretsManager(t, map[string]fakeAWSSecret{
+		"throttled": {
+			current: `{"API_KEY": "after-throttling"}`,
+			errors:  []string{"ThrottlingException", "ThrottlingException"},
+		},
+		"unavailable": {
+			current: `{"API_KEY": "after-server-error"}`,
+			errors:  []string{"InternalServiceError"},
+		},
+		"denied":         {alwaysError: "AccessDeniedException"},
+		"kms":            {alwaysError: "DecryptionFailure"},
+		"always-limited": {alwaysError: "ThrottlingException"},
+	})
+	defer server.Close()
+
+	testCases := []struct {
+		name          string
+		secretName    string
+		maxRetries    int
+		timeout       time.Duration
+		wants         map[string]interface{}
+		wantsErr      error
+		wantsAttempts int
+	}{
+		{
+			name:          "retry throttling",
+			secretName:    "throttled",
+			wants:         map[string]interface{}{"API_KEY": "after-throttling"},
+			wantsAttempts: 3,
+		},
+		{
+			name:          "retry server error",
+			secretName:    "unavailable",
+			wants:         map[string]interface{}{"API_KEY": "after-server-error"},
+			wantsAttempts: 2,
+		},
+		{
+			name:          "not found is not retried",
+			secretName:    "missing",
+			wantsErr:      awsSecretsManager.ErrSecretNotFound,
+			wantsAttempts: 1,
+		},
+		{
+			name:          "access denied is not retried",
+			secretName:    "denied",
+			wantsErr:      awsSecretsManager.ErrAccessDenied,
+			wantsAttempts: 1,
+		},
+		{
+			name:          "decryption failure is not retried",
+			secretName:    "kms",
+			wantsErr:      awsSecretsManager.ErrDecryptionFailure,
+			wantsAttempts: 1,
+		},
+		{
+			name:          "retries exhausted",
+			secretName:    "always-limited",
+			maxRetries:    2,
+			wantsErr:      awsSecretsManager.ErrThrottled,
+			wantsAttempts: 3,
+		},
+		{
+			name:       "timeout",
+			secretName: "always-limited",
+			maxRetries: 100,
+			timeout:    300 * time.Millisecond,
+			wantsErr:   awsSecretsManager.ErrTimeout,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			fake.attempts = map[string]int{}
+			cfg := &awsSecretsManager.Config{
+				Region:      "us-east-1",
+				SecretName:  aws.String(testCase.secretName),
+				EndpointURL: server.URL,
+				MaxRetries:  testCase.maxRetries,
+				Timeout:     testCase.timeout,
+			}
+
+			secretData, err := awsSecretsManager.RetrieveSecret(cfg)
+			if testCase.wantsErr != nil {
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("error retrieving secret data: %v", err)
+			}
+
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+			if attempts := fake.attempts[testCase.secretName]; testCase.wantsAttempts != 0 && attempts != testCase.wantsAttempts {
+				t.Errorf("attempts = %d, wants %d", attempts, testCase.wantsAttempts)
+			}
+		})
+	}
+}
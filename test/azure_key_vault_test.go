@@ -0,0 +1,332 @@
+package test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	azure "github.com/doitintl/secrets-consumer-env/pkg/azure"
+	"github.com/google/go-cmp/cmp"
+	"github.com/magiconair/properties/assert"
+)
+
+const fakeAzureAccessToken = "azure-access-token"
+
+// fakeAzureKeyVault is a stand-in for the Key Vault REST API, secrets are keyed by name
+// and by name/version, the latest version is the one keyed by name
+type fakeAzureKeyVault struct {
+	mu           sync.Mutex
+	secrets      map[string]string
+	certificates map[string][]byte
+	requests     []string
+}
+
+func (f *fakeAzureKeyVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.URL.Path)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+fakeAzureAccessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": {"code": "Unauthorized", "message": "AKV10000: Request is missing a Bearer or PoP token."}}`)
+		return
+	}
+	if r.URL.Query().Get("api-version") != azure.APIVersion {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"code": "BadParameter", "message": "The specified version is not supported"}}`)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/secrets/"):
+		name := strings.TrimPrefix(r.URL.Path, "/secrets/")
+		if value, ok := f.secrets[name]; ok {
+			json.NewEncoder(w).Encode(map[string]string{"value": value, "id": "https://fake.vault.azure.net" + r.URL.Path})
+			return
+		}
+	case strings.HasPrefix(r.URL.Path, "/certificates/"):
+		name := strings.TrimPrefix(r.URL.Path, "/certificates/")
+		if der, ok := f.certificates[name]; ok {
+			json.NewEncoder(w).Encode(map[string]string{"cer": base64.StdEncoding.EncodeToString(der)})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, `{"error": {"code": "SecretNotFound", "message": "A secret with (name/id) %s was not found in this key vault."}}`, r.URL.Path)
+}
+
+// newFakeAzureAD serves the Azure AD token endpoint, recording the form of the token request
+func newFakeAzureAD(t *testing.T, form *map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fake-tenant/oauth2/v2.0/token" || r.ParseForm() != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		*form = make(map[string]string)
+		for key := range r.PostForm {
+			(*form)[key] = r.PostForm.Get(key)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": %q}`, fakeAzureAccessToken)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAzureRetrieveSecrets(t *testing.T) {
+	certificate := []byte("fake DER certificate")
+	keyVault := &fakeAzureKeyVault{
+		secrets: map[string]string{
+			"db-password":            "pa33w0rd",
+			"db-password/0123456789": "old-pa33w0rd",
+			"app-config":             `{"API_KEY": "top-secret-key-123", "DEBUG": "false"}`,
+		},
+		certificates: map[string][]byte{"tls-cert": certificate},
+	}
+	vaultServer := httptest.NewServer(keyVault)
+	defer vaultServer.Close()
+
+	var form map[string]string
+	aad := newFakeAzureAD(t, &form)
+
+	testCases := []struct {
+		name            string
+		secretName      string
+		secretVersion   string
+		secretConfigs   []string
+		certificates    []string
+		wants           map[string]interface{}
+		wantsErr        error
+		wantsErrMessage string
+	}{
+		{
+			name:       "normalized secret name",
+			secretName: "db-password",
+			wants:      map[string]interface{}{"DB_PASSWORD": "pa33w0rd"},
+		},
+		{
+			name:          "secret version",
+			secretName:    "db-password",
+			secretVersion: "0123456789",
+			wants:         map[string]interface{}{"DB_PASSWORD": "old-pa33w0rd"},
+		},
+		{
+			name: "secret configs and certificates",
+			secretConfigs: []string{
+				`{"name": "app-config", "format": "json"}`,
+				`{"name": "db-password", "env": "DATABASE_PASSWORD"}`,
+			},
+			certificates: []string{"tls-cert"},
+			wants: map[string]interface{}{
+				"API_KEY":           "top-secret-key-123",
+				"DEBUG":             "false",
+				"DATABASE_PASSWORD": "pa33w0rd",
+				"TLS_CERT":          string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})),
+			},
+		},
+		{
+			name:       "missing secret",
+			secretName: "missing",
+			wantsErr:   azure.ErrSecretNotFound,
+		},
+		{
+			name:            "plain text secret as json",
+			secretConfigs:   []string{`{"name": "db-password", "format": "json"}`},
+			wantsErrMessage: "use the raw format for plain text secrets",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			secretsConfigList, err := azure.ConfigureSecrets(testCase.secretConfigs)
+			if err != nil {
+				t.Fatalf("error configuring secrets: %v", err)
+			}
+			cfg := &azure.Config{
+				VaultURL:          vaultServer.URL,
+				SecretName:        testCase.secretName,
+				SecretVersion:     testCase.secretVersion,
+				SecretsConfigList: append(secretsConfigList, azure.CertificateConfigs(testCase.certificates)...),
+				TenantID:          "fake-tenant",
+				ClientID:          "fake-client",
+				ClientSecret:      "fake-secret",
+				AuthorityHost:     aad.URL,
+			}
+			client, err := azure.NewClient(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("error creating Key Vault client: %v", err)
+			}
+
+			secretData, err := azure.RetrieveSecret(client, cfg)
+			switch {
+			case testCase.wantsErr != nil:
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+				return
+			case testCase.wantsErrMessage != "":
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+
+	assert.Equal(t, form["grant_type"], "client_credentials")
+	assert.Equal(t, form["client_id"], "fake-client")
+	assert.Equal(t, form["client_secret"], "fake-secret")
+	assert.Equal(t, form["scope"], "https://vault.azure.net/.default")
+}
+
+func TestAzureCredentials(t *testing.T) {
+	keyVault := &fakeAzureKeyVault{secrets: map[string]string{"db-password": "pa33w0rd"}}
+	vaultServer := httptest.NewServer(keyVault)
+	defer vaultServer.Close()
+
+	var form map[string]string
+	aad := newFakeAzureAD(t, &form)
+
+	var imdsQuery map[string]string
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, "missing Metadata header", http.StatusBadRequest)
+			return
+		}
+		imdsQuery = map[string]string{
+			"resource":  r.URL.Query().Get("resource"),
+			"client_id": r.URL.Query().Get("client_id"),
+		}
+		fmt.Fprintf(w, `{"access_token": %q, "expires_on": "%d", "token_type": "Bearer"}`, fakeAzureAccessToken, time.Now().Add(time.Hour).Unix())
+	}))
+	defer imds.Close()
+
+	dir, err := ioutil.TempDir("", "azure-workload-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "azure-identity-token")
+	if err := ioutil.WriteFile(tokenFile, []byte("federated-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		cfg         azure.Config
+		wantsMethod string
+		wantsForm   map[string]string
+		wantsIMDS   map[string]string
+	}{
+		{
+			name:        "workload identity",
+			cfg:         azure.Config{TenantID: "fake-tenant", ClientID: "fake-client", FederatedTokenFile: tokenFile, AuthorityHost: aad.URL},
+			wantsMethod: azure.AuthWorkloadIdentity,
+			wantsForm: map[string]string{
+				"grant_type":            "client_credentials",
+				"client_id":             "fake-client",
+				"client_assertion_type": "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+				"client_assertion":      "federated-token",
+				"scope":                 "https://vault.azure.net/.default",
+			},
+		},
+		{
+			name:        "user assigned managed identity",
+			cfg:         azure.Config{ClientID: "fake-identity", ManagedIdentityEndpoint: imds.URL},
+			wantsMethod: azure.AuthManagedIdentity,
+			wantsIMDS:   map[string]string{"resource": "https://vault.azure.net", "client_id": "fake-identity"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			form, imdsQuery = nil, nil
+			cfg := testCase.cfg
+			cfg.VaultURL = vaultServer.URL
+			cfg.SecretName = "db-password"
+			assert.Equal(t, cfg.AuthMethod(), testCase.wantsMethod)
+
+			client, err := azure.NewClient(context.Background(), &cfg)
+			if err != nil {
+				t.Fatalf("error creating Key Vault client: %v", err)
+			}
+			secretData, err := azure.RetrieveSecret(client, &cfg)
+			if err != nil {
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			assert.Equal(t, secretData["DB_PASSWORD"], "pa33w0rd")
+			if !cmp.Equal(form, testCase.wantsForm) {
+				t.Errorf("token request = diff %v", cmp.Diff(form, testCase.wantsForm))
+			}
+			if !cmp.Equal(imdsQuery, testCase.wantsIMDS) {
+				t.Errorf("managed identity request = diff %v", cmp.Diff(imdsQuery, testCase.wantsIMDS))
+			}
+		})
+	}
+}
+
+func TestAzureWorkloadIdentityTokenRotation(t *testing.T) {
+	var form map[string]string
+	aad := newFakeAzureAD(t, &form)
+
+	dir, err := ioutil.TempDir("", "azure-workload-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "azure-identity-token")
+	if err := ioutil.WriteFile(tokenFile, []byte("federated-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &azure.Config{TenantID: "fake-tenant", ClientID: "fake-client", FederatedTokenFile: tokenFile, AuthorityHost: aad.URL}
+	tokenSource, err := azure.NewTokenSource(context.Background(), cfg, "https://vault.azure.net/.default")
+	if err != nil {
+		t.Fatalf("error creating token source: %v", err)
+	}
+
+	// the kubelet rotates the projected token after the credential is created
+	if err := ioutil.WriteFile(tokenFile, []byte("rotated-federated-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenSource.Token(); err != nil {
+		t.Fatalf("error getting token: %v", err)
+	}
+	assert.Equal(t, form["client_assertion"], "rotated-federated-token")
+}
+
+func TestAzureNames(t *testing.T) {
+	assert.Equal(t, azure.NormalizeName("db-password"), "DB_PASSWORD")
+	assert.Equal(t, azure.NormalizeName("Stripe-Key2"), "STRIPE_KEY2")
+	assert.Equal(t, azure.VaultURL("payments"), "https://payments.vault.azure.net")
+	assert.Equal(t, azure.Scope("https://payments.vault.azure.cn/"), "https://vault.azure.cn/.default")
+	assert.Equal(t, azure.Scope("http://127.0.0.1:8200"), "https://vault.azure.net/.default")
+
+	for _, secretConfig := range []string{
+		`{"version": "1"}`,
+		`{"name": "tls", "kind": "key"}`,
+		`{"name": "tls", "kind": "certificate", "format": "json"}`,
+	} {
+		if _, err := azure.ConfigureSecrets([]string{secretConfig}); err == nil {
+			t.Errorf("expected an error for secret config %s", secretConfig)
+		}
+	}
+}
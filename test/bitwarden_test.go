@@ -0,0 +1,163 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/bitwarden"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeBitwardenServe is a stand-in for the Vault Management API of `bw serve`
+type fakeBitwardenServe struct {
+	locked bool
+	synced bool
+	items  []map[string]interface{}
+}
+
+func (f *fakeBitwardenServe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	reply := func(data interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": data})
+	}
+	if f.locked {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"success": false, "message": "Vault is locked."}`)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/sync":
+		f.synced = true
+		reply(map[string]string{"object": "message", "title": "Syncing complete."})
+	case r.URL.Path == "/list/object/items":
+		search := r.URL.Query().Get("search")
+		items := []map[string]interface{}{}
+		for _, item := range f.items {
+			if strings.Contains(item["name"].(string), search) {
+				items = append(items, item)
+			}
+		}
+		reply(map[string]interface{}{"object": "list", "data": items})
+	case strings.HasPrefix(r.URL.Path, "/object/item/"):
+		for _, item := range f.items {
+			if item["id"] == strings.TrimPrefix(r.URL.Path, "/object/item/") {
+				reply(item)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"success": false, "message": "Not found."}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBitwardenRetrieveSecret(t *testing.T) {
+	items := []map[string]interface{}{
+		{
+			"id":    "0b6f8b2c-5d3a-4f6e-9a51-2f7c1d0e4a11",
+			"name":  "Payments DB",
+			"login": map[string]interface{}{"username": "payments", "password": "pa33w0rd"},
+			"fields": []map[string]interface{}{
+				{"name": "db host", "value": "db.internal", "type": 0},
+			},
+		},
+		{
+			"id":    "7a2e4c19-8b0d-4e3f-a6c2-91d5f0b3e722",
+			"name":  "Payments DB replica",
+			"login": map[string]interface{}{"username": "replica", "password": "replica-pa33w0rd"},
+		},
+		{
+			"id":   "c3d9e1f0-2a4b-4c6d-8e0f-1a2b3c4d5e66",
+			"name": "API",
+			"fields": []map[string]interface{}{
+				{"name": "api-key", "value": "top-secret-key-123", "type": 1},
+			},
+		},
+		{"id": "11111111-2222-4333-8444-555555555555", "name": "Duplicate"},
+		{"id": "66666666-7777-4888-9999-000000000000", "name": "Duplicate"},
+	}
+
+	testCases := []struct {
+		name            string
+		locked          bool
+		items           []string
+		wants           map[string]interface{}
+		wantsErr        error
+		wantsErrMessage string
+	}{
+		{
+			name:  "items by exact name and ID",
+			items: []string{"Payments DB", "c3d9e1f0-2a4b-4c6d-8e0f-1a2b3c4d5e66"},
+			wants: map[string]interface{}{
+				"USERNAME": "payments",
+				"PASSWORD": "pa33w0rd",
+				"DB_HOST":  "db.internal",
+				"API_KEY":  "top-secret-key-123",
+			},
+		},
+		{
+			name:     "missing item",
+			items:    []string{"Missing"},
+			wantsErr: bitwarden.ErrItemNotFound,
+		},
+		{
+			name:     "missing ID",
+			items:    []string{"99999999-2222-4333-8444-555555555555"},
+			wantsErr: bitwarden.ErrItemNotFound,
+		},
+		{
+			name:            "ambiguous name",
+			items:           []string{"Duplicate"},
+			wantsErrMessage: "2 items are named Duplicate",
+		},
+		{
+			name:            "locked vault",
+			locked:          true,
+			items:           []string{"Payments DB"},
+			wantsErrMessage: "Vault is locked.",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			fake := &fakeBitwardenServe{locked: testCase.locked, items: items}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			cfg := &bitwarden.Config{Host: server.URL, Items: testCase.items, Sync: true}
+			client, err := bitwarden.NewClient(cfg)
+			if err != nil {
+				t.Fatalf("error creating Bitwarden client: %v", err)
+			}
+
+			secretData, err := bitwarden.RetrieveSecret(client, cfg)
+			switch {
+			case testCase.wantsErr != nil:
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+				return
+			case testCase.wantsErrMessage != "":
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !fake.synced {
+				t.Error("the vault was not synced")
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	consul "github.com/doitintl/secrets-consumer-env/pkg/consul"
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	"github.com/google/go-cmp/cmp"
+)
+
+// newFakeConsul starts a fake Consul KV HTTP API requiring a client certificate, with a default deny
+// ACL only the token can read the keys with, in the dc1 datacenter, and returns its address
+func newFakeConsul(t *testing.T, pki *testPKI, token string, keys map[string]string) string {
+	server := pki.newMutualTLSServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/v1/kv/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Consul-Token") != token {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+		if dc := r.URL.Query().Get("dc"); dc != "" && dc != "dc1" {
+			http.Error(w, "No path to datacenter", http.StatusInternalServerError)
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		_, recurse := r.URL.Query()["recurse"]
+		var pairs []consul.KVPair
+		for name, value := range keys {
+			if name == key || (recurse && strings.HasPrefix(name, key)) {
+				pairs = append(pairs, consul.KVPair{Key: name, Value: []byte(value)})
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		json.NewEncoder(w).Encode(pairs)
+	}))
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+// newConsulAgent starts a local Consul dev agent serving HTTPS with client certificate verification
+// and default deny ACLs, seeds the keys with the management token and returns the agent address,
+// the test is skipped without a consul binary on the PATH
+func newConsulAgent(t *testing.T, pki *testPKI, managementToken string, keys map[string]string) string {
+	binary, err := exec.LookPath("consul")
+	if err != nil {
+		t.Skip("consul not found on the PATH, install Consul to run the Consul KV tests")
+	}
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	httpsPort := freePort(t)
+	config := map[string]interface{}{
+		"node_name":   "secrets-consumer-env-test",
+		"bind_addr":   "127.0.0.1",
+		"client_addr": "127.0.0.1",
+		"log_level":   "warn",
+		"ports": map[string]interface{}{
+			"http":     -1,
+			"https":    httpsPort,
+			"dns":      -1,
+			"grpc":     -1,
+			"serf_lan": freePort(t),
+			"serf_wan": freePort(t),
+			"server":   freePort(t),
+		},
+		"acl": map[string]interface{}{
+			"enabled":        true,
+			"default_policy": "deny",
+			"tokens":         map[string]string{"initial_management": managementToken},
+		},
+		"tls": map[string]interface{}{
+			"https": map[string]interface{}{
+				"ca_file":         pki.CACert,
+				"cert_file":       pki.ServerCert,
+				"key_file":        pki.ServerKey,
+				"verify_incoming": true,
+			},
+		},
+	}
+	configFile := filepath.Join(dir, "config.json")
+	encoded, _ := json.Marshal(config)
+	if err := ioutil.WriteFile(configFile, encoded, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	agent := exec.Command(binary, "agent", "-dev", "-config-file", configFile)
+	var output bytes.Buffer
+	agent.Stdout, agent.Stderr = &output, &output
+	if err := agent.Start(); err != nil {
+		t.Fatalf("error starting consul: %v", err)
+	}
+	t.Cleanup(func() {
+		agent.Process.Kill()
+		agent.Wait()
+	})
+
+	tlsConfig, err := tlsconfig.New(&tlsconfig.Config{CACert: pki.CACert, ClientCert: pki.ClientCert, ClientKey: pki.ClientKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	address := fmt.Sprintf("127.0.0.1:%d", httpsPort)
+
+	// the keys can be written once the agent is up and the ACLs bootstrapped
+	put := func(key, value string) error {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/v1/kv/%s", address, key), strings.NewReader(value))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Consul-Token", managementToken)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+		}
+		return nil
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for key, value := range keys {
+		for {
+			err := put(key, value)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("error writing Consul key %s: %v\n%s", key, err, output.String())
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+	return address
+}
+
+func TestConsulRetrieveSecret(t *testing.T) {
+	pki := newTestPKI(t)
+	keys := map[string]string{
+		"config/payments/":            "",
+		"config/payments/DB_PASSWORD": "pa33w0rd",
+		"config/payments/app":         `{"API_KEY": "top-secret-key-123", "DEBUG": "false"}`,
+		"config/shared/DEBUG":         "true",
+	}
+	tlsConfig := tlsconfig.Config{CACert: pki.CACert, ClientCert: pki.ClientCert, ClientKey: pki.ClientKey}
+
+	testCases := []struct {
+		name            string
+		token           string
+		datacenter      string
+		keys            []string
+		tls             tlsconfig.Config
+		wants           map[string]interface{}
+		wantsErr        error
+		wantsErrMessage string
+	}{
+		{
+			name:  "prefix and key",
+			token: "consul-acl-token",
+			keys:  []string{"config/payments/", "config/shared/DEBUG"},
+			tls:   tlsConfig,
+			wants: map[string]interface{}{
+				"DB_PASSWORD": "pa33w0rd",
+				"API_KEY":     "top-secret-key-123",
+				"DEBUG":       "true",
+			},
+		},
+		{
+			name:     "missing key",
+			token:    "consul-acl-token",
+			keys:     []string{"config/missing"},
+			tls:      tlsConfig,
+			wantsErr: consul.ErrKeyNotFound,
+		},
+		{
+			name:       "datacenter",
+			token:      "consul-acl-token",
+			datacenter: "dc1",
+			keys:       []string{"config/shared/DEBUG"},
+			tls:        tlsConfig,
+			wants:      map[string]interface{}{"DEBUG": "true"},
+		},
+		{
+			name:            "unknown datacenter",
+			token:           "consul-acl-token",
+			datacenter:      "eu-west",
+			keys:            []string{"config/shared/DEBUG"},
+			tls:             tlsConfig,
+			wantsErrMessage: "status 500",
+		},
+		{
+			name:            "bad token",
+			token:           "other-token",
+			keys:            []string{"config/payments/"},
+			tls:             tlsConfig,
+			wantsErrMessage: "the ACL token needs key read access",
+		},
+		{
+			name:            "no client certificate",
+			token:           "consul-acl-token",
+			keys:            []string{"config/payments/"},
+			tls:             tlsconfig.Config{CACert: pki.CACert},
+			wantsErrMessage: "failed to get Consul key",
+		},
+	}
+
+	// the fake server always runs, the Consul agent when installed
+	servers := []struct {
+		name  string
+		start func(t *testing.T) string
+	}{
+		{name: "fake server", start: func(t *testing.T) string { return newFakeConsul(t, pki, "consul-acl-token", keys) }},
+		{name: "consul agent", start: func(t *testing.T) string { return newConsulAgent(t, pki, "consul-acl-token", keys) }},
+	}
+
+	for _, server := range servers {
+		t.Run(server.name, func(t *testing.T) {
+			address := server.start(t)
+			for _, testCase := range testCases {
+				t.Run(testCase.name, func(t *testing.T) {
+					cfg := &consul.Config{
+						Address:    address,
+						Token:      testCase.token,
+						Datacenter: testCase.datacenter,
+						Keys:       testCase.keys,
+						TLS:        testCase.tls,
+					}
+					client, err := consul.NewClient(cfg)
+					if err != nil {
+						t.Fatalf("error creating Consul client: %v", err)
+					}
+
+					secretData, err := consul.RetrieveSecret(client, cfg)
+					switch {
+					case testCase.wantsErr != nil:
+						if !errors.Is(err, testCase.wantsErr) {
+							t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+						}
+						return
+					case testCase.wantsErrMessage != "":
+						if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+							t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+						}
+						return
+					case err != nil:
+						t.Fatalf("error retrieving secret data %v", err)
+					}
+					if !cmp.Equal(secretData, testCase.wants) {
+						t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+					}
+				})
+			}
+		})
+	}
+}
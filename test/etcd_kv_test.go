@@ -0,0 +1,220 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	etcd "github.com/doitintl/secrets-consumer-env/pkg/etcd"
+	"github.com/google/go-cmp/cmp"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// newEmbeddedEtcd starts an etcd server with a plain and a mutual TLS client URL, seeds the keys and
+// enables authentication with a user that can read the keys with the password
+func newEmbeddedEtcd(t *testing.T, pki *testPKI, user, password string, keys map[string]string) (plainURL, tlsURL string) {
+	dir, err := ioutil.TempDir("", "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	// the JSON gateway dials the client URLs, they need their port
+	plainURL = fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+	tlsURL = fmt.Sprintf("https://127.0.0.1:%d", freePort(t))
+	for _, clientURL := range []string{plainURL, tlsURL} {
+		u, _ := url.Parse(clientURL)
+		cfg.LCUrls = append(cfg.LCUrls, *u)
+	}
+	cfg.ACUrls = cfg.LCUrls
+	cfg.LPUrls = []url.URL{{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", freePort(t))}}
+	cfg.APUrls = cfg.LPUrls
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	cfg.ClientTLSInfo.CertFile = pki.ServerCert
+	cfg.ClientTLSInfo.KeyFile = pki.ServerKey
+	cfg.ClientTLSInfo.TrustedCAFile = pki.CACert
+	cfg.ClientTLSInfo.ClientCertAuth = true
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("error starting etcd: %v", err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatal("etcd did not start")
+	}
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{plainURL}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+	for key, value := range keys {
+		if _, err := client.Put(ctx, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setup := []func() error{
+		func() error { _, err := client.UserAdd(ctx, "root", "root-pa33w0rd"); return err },
+		func() error { _, err := client.RoleAdd(ctx, "root"); return err },
+		func() error { _, err := client.UserGrantRole(ctx, "root", "root"); return err },
+		func() error { _, err := client.UserAdd(ctx, user, password); return err },
+		func() error { _, err := client.RoleAdd(ctx, "reader"); return err },
+		func() error {
+			_, err := client.RoleGrantPermission(ctx, "reader", "\x00", "\x00", clientv3.PermissionType(clientv3.PermRead))
+			return err
+		},
+		func() error { _, err := client.UserGrantRole(ctx, user, "reader"); return err },
+		func() error { _, err := client.AuthEnable(ctx); return err },
+	}
+	for _, step := range setup {
+		if err := step(); err != nil {
+			t.Fatalf("error setting up etcd authentication: %v", err)
+		}
+	}
+	return plainURL, tlsURL
+}
+
+func TestEtcdRetrieveSecret(t *testing.T) {
+	pki := newTestPKI(t)
+	plainURL, tlsURL := newEmbeddedEtcd(t, pki, "app", "etcd-pa33w0rd", map[string]string{
+		"/config/payments/DB_PASSWORD": "pa33w0rd",
+		"/config/payments/app":         `{"API_KEY": "top-secret-key-123", "DEBUG": "false"}`,
+		"/config/payments0/OTHER":      "not under the prefix",
+		"/config/shared/DEBUG":         "true",
+	})
+
+	testCases := []struct {
+		name            string
+		cfg             etcd.Config
+		clientCert      string
+		wants           map[string]interface{}
+		wantsErr        error
+		wantsErrMessage string
+	}{
+		{
+			name: "prefix and key with TLS client auth",
+			cfg: etcd.Config{
+				Endpoints: []string{tlsURL},
+				Username:  "app",
+				Password:  "etcd-pa33w0rd",
+				Keys:      []string{"/config/payments/", "/config/shared/DEBUG"},
+			},
+			clientCert: "anonymous",
+			wants: map[string]interface{}{
+				"DB_PASSWORD": "pa33w0rd",
+				"API_KEY":     "top-secret-key-123",
+				"DEBUG":       "true",
+			},
+		},
+		{
+			name: "client certificate with a CommonName",
+			cfg: etcd.Config{
+				Endpoints: []string{tlsURL},
+				Username:  "app",
+				Password:  "etcd-pa33w0rd",
+				Keys:      []string{"/config/shared/DEBUG"},
+			},
+			clientCert:      "named",
+			wantsErrMessage: "CommonName of client sending a request against gateway will be ignored",
+		},
+		{
+			name: "no client certificate",
+			cfg: etcd.Config{
+				Endpoints: []string{tlsURL},
+				Username:  "app",
+				Password:  "etcd-pa33w0rd",
+				Keys:      []string{"/config/shared/DEBUG"},
+			},
+			wantsErrMessage: "no etcd endpoint is reachable",
+		},
+		{
+			name: "unreachable endpoint is skipped",
+			cfg: etcd.Config{
+				Endpoints: []string{"http://127.0.0.1:1", plainURL},
+				Username:  "app",
+				Password:  "etcd-pa33w0rd",
+				Keys:      []string{"/config/shared/DEBUG"},
+			},
+			wants: map[string]interface{}{"DEBUG": "true"},
+		},
+		{
+			name: "missing key",
+			cfg: etcd.Config{
+				Endpoints: []string{plainURL},
+				Username:  "app",
+				Password:  "etcd-pa33w0rd",
+				Keys:      []string{"/config/missing"},
+			},
+			wantsErr: etcd.ErrKeyNotFound,
+		},
+		{
+			name: "bad password",
+			cfg: etcd.Config{
+				Endpoints: []string{plainURL},
+				Username:  "app",
+				Password:  "wrong",
+				Keys:      []string{"/config/shared/DEBUG"},
+			},
+			wantsErrMessage: "authentication failed",
+		},
+		{
+			name: "no credentials",
+			cfg: etcd.Config{
+				Endpoints: []string{plainURL},
+				Keys:      []string{"/config/shared/DEBUG"},
+			},
+			wantsErrMessage: "failed to get etcd key /config/shared/DEBUG",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := testCase.cfg
+			if strings.HasPrefix(cfg.Endpoints[0], "https://") {
+				cfg.TLS.CACert = pki.CACert
+				switch testCase.clientCert {
+				case "anonymous":
+					cfg.TLS.ClientCert, cfg.TLS.ClientKey = pki.AnonymousClientCert, pki.AnonymousClientKey
+				case "named":
+					cfg.TLS.ClientCert, cfg.TLS.ClientKey = pki.ClientCert, pki.ClientKey
+				}
+			}
+			client, err := etcd.NewClient(&cfg)
+			var secretData map[string]interface{}
+			if err == nil {
+				secretData, err = etcd.RetrieveSecret(client, &cfg)
+			}
+			switch {
+			case testCase.wantsErr != nil:
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+				return
+			case testCase.wantsErrMessage != "":
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	file "github.com/doitintl/secrets-consumer-env/pkg/file"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeSOPS is a stand-in for the sops binary, it records its arguments and SOPS_AGE_KEY_FILE
+// and prints the decrypted file next to the encrypted one
+const fakeSOPS = `#!/bin/sh
+echo "$@ $SOPS_AGE_KEY_FILE" > "$(dirname "$0")/sops.args"
+for last; do :; done
+cat "$last.decrypted"
+`
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileRetrieveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, dir, map[string]string{
+		".env":                       "API_KEY=top-secret-key-123\nexport DEBUG=false\n# comment\nMESSAGE=\"hello world\"\n",
+		"config.json":                `{"DEBUG": "true", "PORT": 8080}`,
+		"config.yaml":                "DB_PASSWORD: pa33w0rd\ndb:\n  user: admin\n",
+		"secrets.enc.yaml":           "DB_PASSWORD: ENC[AES256_GCM,data:abc,type:str]\nsops:\n    age:\n        - recipient: age1fake\n    version: 3.7.3\n",
+		"secrets.enc.yaml.decrypted": "DB_PASSWORD: s3cr3t\n",
+		"sops":                       fakeSOPS,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	testCases := []struct {
+		name            string
+		cfg             file.Config
+		wants           map[string]interface{}
+		wantsSOPSArgs   string
+		wantsErrMessage string
+	}{
+		{
+			name: "dotenv, json and yaml in order",
+			cfg:  file.Config{Paths: []string{path(".env"), path("config.json"), path("config.yaml")}},
+			wants: map[string]interface{}{
+				"API_KEY":     "top-secret-key-123",
+				"DEBUG":       "true",
+				"MESSAGE":     "hello world",
+				"PORT":        float64(8080),
+				"DB_PASSWORD": "pa33w0rd",
+				"db":          map[string]interface{}{"user": "admin"},
+			},
+		},
+		{
+			name: "sops encrypted file with age key",
+			cfg: file.Config{
+				Paths:      []string{path("config.yaml"), path("secrets.enc.yaml")},
+				SOPSBinary: path("sops"),
+				AgeKeyFile: "/keys/age.txt",
+			},
+			wants: map[string]interface{}{
+				"DB_PASSWORD": "s3cr3t",
+				"db":          map[string]interface{}{"user": "admin"},
+			},
+			wantsSOPSArgs: "--decrypt --input-type yaml --output-type yaml " + path("secrets.enc.yaml") + " /keys/age.txt",
+		},
+		{
+			name:            "sops encrypted file without sops",
+			cfg:             file.Config{Paths: []string{path("secrets.enc.yaml")}, SOPSBinary: path("missing-sops")},
+			wantsErrMessage: "sops is needed to decrypt",
+		},
+		{
+			name:            "unknown format",
+			cfg:             file.Config{Paths: []string{path("secrets.enc.yaml.decrypted")}},
+			wantsErrMessage: "unknown format of file",
+		},
+		{
+			name: "explicit format",
+			cfg:  file.Config{Paths: []string{path("secrets.enc.yaml.decrypted")}, Format: file.FormatYAML},
+			wants: map[string]interface{}{
+				"DB_PASSWORD": "s3cr3t",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			os.Remove(path("sops.args"))
+			secretData, err := file.RetrieveSecret(&testCase.cfg)
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error reading secret files %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+			if testCase.wantsSOPSArgs != "" {
+				args, err := ioutil.ReadFile(path("sops.args"))
+				if err != nil {
+					t.Fatalf("sops was not called: %v", err)
+				}
+				if strings.TrimSpace(string(args)) != testCase.wantsSOPSArgs {
+					t.Errorf("sops args = %q, expected %q", strings.TrimSpace(string(args)), testCase.wantsSOPSArgs)
+				}
+			}
+		})
+	}
+}
+
+func TestFileIsSOPSEncrypted(t *testing.T) {
+	testCases := []struct {
+		data   string
+		format string
+		wants  bool
+	}{
+		{data: "API_KEY=ENC[AES256_GCM,data:abc]\nsops_version=3.7.3\n", format: file.FormatDotenv, wants: true},
+		{data: "API_KEY=plain\n", format: file.FormatDotenv},
+		{data: `{"API_KEY": "ENC[...]", "sops": {"version": "3.7.3"}}`, format: file.FormatJSON, wants: true},
+		{data: `{"API_KEY": "sops"}`, format: file.FormatJSON},
+		{data: "API_KEY: plain\nsops:\n    version: 3.7.3\n", format: file.FormatYAML, wants: true},
+		{data: "API_KEY: plain\n", format: file.FormatYAML},
+	}
+	for _, testCase := range testCases {
+		if got := file.IsSOPSEncrypted([]byte(testCase.data), testCase.format); got != testCase.wants {
+			t.Errorf("IsSOPSEncrypted(%q, %s) = %v, expected %v", testCase.data, testCase.format, got, testCase.wants)
+		}
+	}
+}
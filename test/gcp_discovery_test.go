@@ -0,0 +1,279 @@
+package test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	gcpSecretsManager "github.com/doitintl/secrets-consumer-env/pkg/gcp"
+	"github.com/google/go-cmp/cmp"
+	"github.com/magiconair/properties/assert"
+	"google.golang.org/api/option"
+	secretspb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type fakeGCPSecretVersion struct {
+	version string
+	payload string
+	enabled bool
+}
+
+type fakeGCPSecret struct {
+	labels   map[string]string
+	versions []fakeGCPSecretVersion
+}
+
+// fakeGCPSecretManager is a stand-in for the Secret Manager gRPC API, its ListSecrets
+// only understands "labels.<key>=<value>" terms joined with AND
+type fakeGCPSecretManager struct {
+	secretspb.UnimplementedSecretManagerServiceServer
+	secrets map[string]fakeGCPSecret
+	filter  string
+}
+
+func (f *fakeGCPSecretManager) ListSecrets(ctx context.Context, req *secretspb.ListSecretsRequest) (*secretspb.ListSecretsResponse, error) {
+	f.filter = req.Filter
+	resp := &secretspb.ListSecretsResponse{}
+	for name, secret := range f.secrets {
+		if !strings.HasPrefix(name, req.Parent+"/secrets/") || !matchesLabels(secret.labels, req.Filter) {
+			continue
+		}
+		resp.Secrets = append(resp.Secrets, &secretspb.Secret{Name: name, Labels: secret.labels})
+	}
+	return resp, nil
+}
+
+func matchesLabels(labels map[string]string, filter string) bool {
+	for _, term := range strings.Split(filter, " AND ") {
+		split := strings.SplitN(strings.TrimPrefix(term, "labels."), "=", 2)
+		if len(split) != 2 || labels[split[0]] != split[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeGCPSecretManager) ListSecretVersions(ctx context.Context, req *secretspb.ListSecretVersionsRequest) (*secretspb.ListSecretVersionsResponse, error) {
+	secret, ok := f.secrets[req.Parent]
+	if !ok {
+		return nil, grpcstatus.Errorf(grpccodes.NotFound, "Secret [%s] not found", req.Parent)
+	}
+	resp := &secretspb.ListSecretVersionsResponse{}
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, version := range secret.versions {
+		if req.Filter == "state:ENABLED" && !version.enabled {
+			continue
+		}
+		state := secretspb.SecretVersion_DISABLED
+		if version.enabled {
+			state = secretspb.SecretVersion_ENABLED
+		}
+		resp.Versions = append(resp.Versions, &secretspb.SecretVersion{
+			Name:       req.Parent + "/versions/" + version.version,
+			State:      state,
+			CreateTime: timestamppb.New(created.Add(time.Duration(i) * time.Hour)),
+		})
+	}
+	return resp, nil
+}
+
+func (f *fakeGCPSecretManager) AccessSecretVersion(ctx context.Context, req *secretspb.AccessSecretVersionRequest) (*secretspb.AccessSecretVersionResponse, error) {
+	for name, secret := range f.secrets {
+		for i, version := range secret.versions {
+			latest := i == len(secret.versions)-1 && req.Name == name+"/versions/latest"
+			if (latest || req.Name == name+"/versions/"+version.version) && version.enabled {
+				return &secretspb.AccessSecretVersionResponse{
+					Name:    req.Name,
+					Payload: &secretspb.SecretPayload{Data: []byte(version.payload)},
+				}, nil
+			}
+		}
+	}
+	return nil, grpcstatus.Errorf(grpccodes.NotFound, "Secret Version [%s] not found", req.Name)
+}
+
+func newFakeGCPSecretManagerClient(t *testing.T, fake *fakeGCPSecretManager) *secretmanager.Client {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	server := grpc.NewServer()
+	secretspb.RegisterSecretManagerServiceServer(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	client, err := secretmanager.NewClient(context.Background(),
+		option.WithEndpoint(listener.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()),
+	)
+	if err != nil {
+		t.Fatalf("error creating secret manager client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestGCPDiscoverSecrets(t *testing.T) {
+	fake := &fakeGCPSecretManager{
+		secrets: map[string]fakeGCPSecret{
+			"projects/fake-project/secrets/checkout-db-password": {
+				labels: map[string]string{"app": "checkout", "env": "prod", "env-name": "db-password"},
+				versions: []fakeGCPSecretVersion{
+					{version: "1", payload: "old-password", enabled: true},
+					{version: "2", payload: "pa33w0rd", enabled: true},
+					{version: "3", payload: "revoked", enabled: false},
+				},
+			},
+			"projects/fake-project/secrets/STRIPE_KEY": {
+				labels:   map[string]string{"app": "checkout", "env": "prod"},
+				versions: []fakeGCPSecretVersion{{version: "1", payload: "sk_live_123", enabled: true}},
+			},
+			"projects/fake-project/secrets/checkout.api-token": {
+				labels:   map[string]string{"app": "checkout", "env": "prod"},
+				versions: []fakeGCPSecretVersion{{version: "4", payload: "t0k3n", enabled: true}},
+			},
+			"projects/fake-project/secrets/checkout-disabled": {
+				labels:   map[string]string{"app": "checkout", "env": "prod"},
+				versions: []fakeGCPSecretVersion{{version: "1", payload: "disabled", enabled: false}},
+			},
+			"projects/fake-project/secrets/checkout-staging": {
+				labels:   map[string]string{"app": "checkout", "env": "staging"},
+				versions: []fakeGCPSecretVersion{{version: "1", payload: "staging", enabled: true}},
+			},
+			"projects/fake-project/secrets/app-config": {
+				versions: []fakeGCPSecretVersion{{version: "1", payload: `{"STRIPE_KEY": "sk_test_456", "DEBUG": "true"}`, enabled: true}},
+			},
+		},
+	}
+	client := newFakeGCPSecretManagerClient(t, fake)
+
+	testCases := []struct {
+		name            string
+		labels          map[string]string
+		nameLabel       string
+		secretName      string
+		wants           map[string]interface{}
+		wantsFilter     string
+		wantsErrMessage string
+	}{
+		{
+			name:        "secret ids as names",
+			labels:      map[string]string{"env": "prod", "app": "checkout"},
+			wantsFilter: "labels.app=checkout AND labels.env=prod",
+			wants: map[string]interface{}{
+				"CHECKOUT_DB_PASSWORD": "pa33w0rd",
+				"CHECKOUT_API_TOKEN":   "t0k3n",
+				"STRIPE_KEY":           "sk_live_123",
+			},
+		},
+		{
+			name:        "names from label",
+			labels:      map[string]string{"app": "checkout", "env": "prod"},
+			nameLabel:   "env-name",
+			wantsFilter: "labels.app=checkout AND labels.env=prod",
+			wants: map[string]interface{}{
+				"DB_PASSWORD":        "pa33w0rd",
+				"CHECKOUT_API_TOKEN": "t0k3n",
+				"STRIPE_KEY":         "sk_live_123",
+			},
+		},
+		{
+			name:        "configured secret wins",
+			labels:      map[string]string{"app": "checkout", "env": "prod"},
+			secretName:  "app-config",
+			wantsFilter: "labels.app=checkout AND labels.env=prod",
+			wants: map[string]interface{}{
+				"CHECKOUT_DB_PASSWORD": "pa33w0rd",
+				"CHECKOUT_API_TOKEN":   "t0k3n",
+				"STRIPE_KEY":           "sk_test_456",
+				"DEBUG":                "true",
+			},
+		},
+		{
+			name:            "no match",
+			labels:          map[string]string{"app": "billing"},
+			wantsFilter:     "labels.app=billing",
+			wantsErrMessage: "could not find enabled secrets",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := &gcpSecretsManager.Config{
+				ProjectID:  "fake-project",
+				SecretName: testCase.secretName,
+				Labels:     testCase.labels,
+				NameLabel:  testCase.nameLabel,
+				UseInTests: true,
+			}
+
+			secretData, err := gcpSecretsManager.RetrieveSecret(client, cfg)
+			assert.Equal(t, fake.filter, testCase.wantsFilter)
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestGCPBuildListSecretsFilter(t *testing.T) {
+	cfg := &gcpSecretsManager.Config{
+		Labels: map[string]string{"env": "prod", "app": "checkout"},
+		Filter: "name:checkout- OR name:payments-",
+	}
+	assert.Equal(t, gcpSecretsManager.BuildListSecretsFilter(cfg), "labels.app=checkout AND labels.env=prod AND (name:checkout- OR name:payments-)")
+
+	if _, err := gcpSecretsManager.ParseLabels([]string{"app"}); err == nil {
+		t.Errorf("expected an error for a label without a value")
+	}
+}
+
+func TestGCPAccessSecretVersions(t *testing.T) {
+	client := newFakeGCPSecretManagerClient(t, &fakeGCPSecretManager{
+		secrets: map[string]fakeGCPSecret{
+			"projects/fake-project/secrets/db": {
+				versions: []fakeGCPSecretVersion{
+					{version: "3", payload: `{"password": "s3cr3t"}`, enabled: true},
+					{version: "4", payload: `{"password": "n3w-s3cr3t"}`, enabled: true},
+				},
+			},
+		},
+	})
+
+	secrets, err := gcpSecretsManager.AccessSecretVersions(client, []string{
+		"projects/fake-project/secrets/db",
+		"projects/fake-project/secrets/db/versions/3",
+	})
+	if err != nil {
+		t.Fatalf("error accessing secret versions %v", err)
+	}
+	wants := map[string]interface{}{
+		"projects/fake-project/secrets/db":            `{"password": "n3w-s3cr3t"}`,
+		"projects/fake-project/secrets/db/versions/3": `{"password": "s3cr3t"}`,
+	}
+	if !cmp.Equal(secrets, wants) {
+		t.Errorf("secrets = diff %v", cmp.Diff(secrets, wants))
+	}
+
+	_, err = gcpSecretsManager.AccessSecretVersions(client, []string{"projects/fake-project/secrets/missing/versions/1"})
+	if err == nil || !strings.Contains(err.Error(), "secret not found") {
+		t.Errorf("expected a not found error, got: %v", err)
+	}
+}
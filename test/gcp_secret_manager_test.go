@@ -2,18 +2,38 @@ package test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	gcpSecretsManager "github.com/doitintl/secrets-consumer-env/pkg/gcp"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	"github.com/google/go-cmp/cmp"
 	"github.com/googleapis/gax-go/v2"
 	"github.com/magiconair/properties/assert"
-	secretspb "google.golang.org/genproto/googleapis/cloud/secrets/v1beta1"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	secretspb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
-type mockGCPSecretManagerClient struct{}
+type mockGCPSecretManagerClient struct {
+	requestName string
+}
 
 func (m *mockGCPSecretManagerClient) AccessSecretVersion(ctx context.Context, req *secretspb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error) {
+	m.requestName = req.Name
 	return &secretspb.AccessSecretVersionResponse{
+		Name: req.Name,
 		Payload: &secretspb.SecretPayload{
 			Data: []byte(`{"API_KEY": "top-secret-key-123"}`),
 		},
@@ -34,4 +54,350 @@ func TestGCPGetSecretData(t *testing.T) {
 		t.Fatalf("error retrieving secret data %v", err)
 	}
 	assert.Equal(t, secretData["API_KEY"], "top-secret-key-123")
+	assert.Equal(t, client.requestName, "projects/fake-project/secrets/top-secret/versions/latest")
+}
+
+// mockGCPSecretsClient serves payloads by secret version resource name
+type mockGCPSecretsClient struct {
+	mu       sync.Mutex
+	payloads map[string]string
+}
+
+func (m *mockGCPSecretsClient) AccessSecretVersion(ctx context.Context, req *secretspb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	payload, ok := m.payloads[req.Name]
+	if !ok {
+		return nil, grpcstatus.Error(grpccodes.NotFound, fmt.Sprintf("Secret [%s] not found", req.Name))
+	}
+	return &secretspb.AccessSecretVersionResponse{
+		Name:    req.Name,
+		Payload: &secretspb.SecretPayload{Data: []byte(payload)},
+	}, nil
+}
+
+type mockGCPChecksumClient struct {
+	data     string
+	checksum *int64
+}
+
+func (m *mockGCPChecksumClient) AccessSecretVersion(ctx context.Context, req *secretspb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretspb.AccessSecretVersionResponse, error) {
+	return &secretspb.AccessSecretVersionResponse{
+		Name:    "projects/fake-project/secrets/top-secret/versions/5",
+		Payload: &secretspb.SecretPayload{Data: []byte(m.data), DataCrc32C: m.checksum},
+	}, nil
+}
+
+func TestGCPPayloadChecksum(t *testing.T) {
+	data := `{"API_KEY": "top-secret-key-123"}`
+	checksum := int64(crc32.Checksum([]byte(data), crc32.MakeTable(crc32.Castagnoli)))
+	badChecksum := checksum + 1
+
+	testCases := []struct {
+		name     string
+		client   *mockGCPChecksumClient
+		wantsErr error
+	}{
+		{
+			name:   "valid checksum",
+			client: &mockGCPChecksumClient{data: data, checksum: &checksum},
+		},
+		{
+			name:   "no checksum",
+			client: &mockGCPChecksumClient{data: data},
+		},
+		{
+			name:     "corrupted payload",
+			client:   &mockGCPChecksumClient{data: data, checksum: &badChecksum},
+			wantsErr: gcpSecretsManager.ErrPayloadCorrupted,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := &secretspb.AccessSecretVersionRequest{Name: "projects/fake-project/secrets/top-secret/versions/latest"}
+			payload, err := gcpSecretsManager.GetSecretData(testCase.client, req)
+			if testCase.wantsErr != nil {
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+				var checksumErr *gcpSecretsManager.PayloadChecksumError
+				if !errors.As(err, &checksumErr) || checksumErr.Expected != badChecksum || checksumErr.Actual != checksum {
+					t.Errorf("unexpected checksum error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error getting secret data: %v", err)
+			}
+			assert.Equal(t, string(payload.Data), data)
+		})
+	}
+}
+
+func TestGCPRetrieveMultipleSecrets(t *testing.T) {
+	client := &mockGCPSecretsClient{
+		payloads: map[string]string{
+			"projects/fake-project/secrets/app-config/versions/latest": `{"API_KEY": "top-secret-key-123", "DEBUG": "false"}`,
+			"projects/fake-project/secrets/overrides/versions/2":       `{"DEBUG": "true"}`,
+			"projects/fake-project/secrets/db-password/versions/3":     "pa33w0rd\n",
+			"projects/other/secrets/STRIPE_KEY/versions/latest":        "sk_live_123",
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		secretName      string
+		secretConfigs   []string
+		wants           map[string]interface{}
+		wantsVersions   map[string]string
+		wantsErrMessage string
+	}{
+		{
+			name:       "secret name with json and raw secret configs",
+			secretName: "app-config",
+			secretConfigs: []string{
+				`{"name": "db-password", "version": "3", "format": "raw", "env": "DB_PASSWORD"}`,
+				`{"name": "projects/other/secrets/STRIPE_KEY", "format": "raw"}`,
+				`{"name": "overrides", "version": "2"}`,
+			},
+			wants: map[string]interface{}{
+				"API_KEY":     "top-secret-key-123",
+				"DEBUG":       "true",
+				"DB_PASSWORD": "pa33w0rd\n",
+				"STRIPE_KEY":  "sk_live_123",
+			},
+			wantsVersions: map[string]string{
+				"API_KEY":     "latest",
+				"DEBUG":       "2",
+				"DB_PASSWORD": "3",
+				"STRIPE_KEY":  "latest",
+			},
+		},
+		{
+			name: "env implies raw",
+			secretConfigs: []string{
+				`{"name": "db-password", "version": "3", "env": "DB_PASSWORD"}`,
+			},
+			wants:         map[string]interface{}{"DB_PASSWORD": "pa33w0rd\n"},
+			wantsVersions: map[string]string{"DB_PASSWORD": "3"},
+		},
+		{
+			name: "plain text secret as json",
+			secretConfigs: []string{
+				`{"name": "db-password", "version": "3"}`,
+			},
+			wantsErrMessage: "use the raw format for plain text secrets",
+		},
+		{
+			name: "missing secret",
+			secretConfigs: []string{
+				`{"name": "app-config"}`,
+				`{"name": "missing", "format": "raw"}`,
+			},
+			wantsErrMessage: "secret not found",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			secretsConfigList, err := gcpSecretsManager.ConfigureSecrets(testCase.secretConfigs)
+			if err != nil {
+				t.Fatalf("error configuring secrets: %v", err)
+			}
+			cfg := &gcpSecretsManager.Config{
+				ProjectID:         "fake-project",
+				SecretName:        testCase.secretName,
+				SecretsConfigList: secretsConfigList,
+				UseInTests:        true,
+				Recorder:          &merge.Recorder{},
+			}
+
+			secretData, err := gcpSecretsManager.RetrieveSecret(client, cfg)
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+			if versions := cfg.Recorder.Versions(); !cmp.Equal(versions, testCase.wantsVersions) {
+				t.Errorf("versions = diff %v", cmp.Diff(versions, testCase.wantsVersions))
+			}
+		})
+	}
+}
+
+func TestGCPConfigureSecretsErrors(t *testing.T) {
+	for _, secretConfig := range []string{
+		`{"version": "3"}`,
+		`{"name": "db", "format": "yaml"}`,
+		`not json`,
+	} {
+		if _, err := gcpSecretsManager.ConfigureSecrets([]string{secretConfig}); err == nil {
+			t.Errorf("expected an error for secret config %s", secretConfig)
+		}
+	}
+}
+
+func TestGCPBuildAccessSecretRequest(t *testing.T) {
+	testCases := []struct {
+		name            string
+		params          *gcpSecretsManager.SecretManagerAccessRequestParams
+		wants           string
+		wantsErr        bool
+		wantsLocation   string
+		wantsIsResource bool
+	}{
+		{
+			name:   "secret id",
+			params: &gcpSecretsManager.SecretManagerAccessRequestParams{Project: "p", Name: "db", Version: "3"},
+			wants:  "projects/p/secrets/db/versions/3",
+		},
+		{
+			name:   "default version",
+			params: &gcpSecretsManager.SecretManagerAccessRequestParams{Project: "p", Name: "db"},
+			wants:  "projects/p/secrets/db/versions/latest",
+		},
+		{
+			name:   "version alias",
+			params: &gcpSecretsManager.SecretManagerAccessRequestParams{Project: "p", Name: "db", Version: "prod-current"},
+			wants:  "projects/p/secrets/db/versions/prod-current",
+		},
+		{
+			name:   "regional secret",
+			params: &gcpSecretsManager.SecretManagerAccessRequestParams{Project: "p", Location: "us-central1", Name: "db", Version: "latest"},
+			wants:  "projects/p/locations/us-central1/secrets/db/versions/latest",
+		},
+		{
+			name:            "full resource name",
+			params:          &gcpSecretsManager.SecretManagerAccessRequestParams{Project: "ignored", Name: "projects/other/secrets/db/versions/7", Version: "latest"},
+			wants:           "projects/other/secrets/db/versions/7",
+			wantsIsResource: true,
+		},
+		{
+			name:            "full resource name without version",
+			params:          &gcpSecretsManager.SecretManagerAccessRequestParams{Name: "projects/other/secrets/db", Version: "2"},
+			wants:           "projects/other/secrets/db/versions/2",
+			wantsIsResource: true,
+		},
+		{
+			name:            "regional full resource name",
+			params:          &gcpSecretsManager.SecretManagerAccessRequestParams{Name: "projects/other/locations/europe-west1/secrets/db"},
+			wants:           "projects/other/locations/europe-west1/secrets/db/versions/latest",
+			wantsLocation:   "europe-west1",
+			wantsIsResource: true,
+		},
+		{
+			name:            "bad resource name",
+			params:          &gcpSecretsManager.SecretManagerAccessRequestParams{Name: "projects/other/db"},
+			wantsErr:        true,
+			wantsIsResource: true,
+		},
+		{
+			name:     "missing project",
+			params:   &gcpSecretsManager.SecretManagerAccessRequestParams{Name: "db"},
+			wantsErr: true,
+		},
+		{
+			name:     "bad version",
+			params:   &gcpSecretsManager.SecretManagerAccessRequestParams{Project: "p", Name: "db", Version: "1/../2"},
+			wantsErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, gcpSecretsManager.IsResourceName(testCase.params.Name), testCase.wantsIsResource)
+			assert.Equal(t, gcpSecretsManager.LocationFromName(testCase.params.Name), testCase.wantsLocation)
+
+			req, err := gcpSecretsManager.BuildAccessSecretRequest(testCase.params)
+			if testCase.wantsErr {
+				if err == nil {
+					t.Fatalf("expected an error, got request %s", req.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error building access request: %v", err)
+			}
+			assert.Equal(t, req.Name, testCase.wants)
+		})
+	}
+}
+
+func TestGCPImpersonatedTokenSource(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	var (
+		requestPath string
+		callerToken string
+		request     struct {
+			Delegates []string `json:"delegates"`
+			Scope     []string `json:"scope"`
+		}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestPath = r.URL.Path
+		callerToken = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"accessToken": "impersonated-token", "expireTime": %q}`, expiry.Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	tokenSource, err := gcpSecretsManager.ImpersonatedTokenSource(
+		context.Background(),
+		"secrets@target-project.iam.gserviceaccount.com",
+		[]string{"delegate@middle-project.iam.gserviceaccount.com"},
+		option.WithEndpoint(server.URL),
+		option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "caller-token"})),
+	)
+	if err != nil {
+		t.Fatalf("error creating impersonated token source: %v", err)
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		t.Fatalf("error generating impersonated token: %v", err)
+	}
+
+	assert.Equal(t, token.AccessToken, "impersonated-token")
+	assert.Equal(t, token.Expiry.Equal(expiry), true)
+	assert.Equal(t, requestPath, "/v1/projects/-/serviceAccounts/secrets@target-project.iam.gserviceaccount.com:generateAccessToken")
+	assert.Equal(t, callerToken, "Bearer caller-token")
+	assert.Equal(t, request.Delegates, []string{"projects/-/serviceAccounts/delegate@middle-project.iam.gserviceaccount.com"})
+	assert.Equal(t, request.Scope, []string{gcpSecretsManager.CloudPlatformScope})
+}
+
+func TestGCPCredentialsEmail(t *testing.T) {
+	testCases := []struct {
+		name  string
+		json  string
+		wants string
+	}{
+		{
+			name:  "service account key",
+			json:  `{"type": "service_account", "client_email": "secrets@project.iam.gserviceaccount.com"}`,
+			wants: "secrets@project.iam.gserviceaccount.com",
+		},
+		{
+			name:  "user credentials",
+			json:  `{"type": "authorized_user", "client_id": "id"}`,
+			wants: "authorized_user",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			creds := &google.Credentials{JSON: []byte(testCase.json)}
+			assert.Equal(t, gcpSecretsManager.CredentialsEmail(creds), testCase.wants)
+		})
+	}
 }
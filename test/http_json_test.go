@@ -0,0 +1,135 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/httpjson"
+	"github.com/doitintl/secrets-consumer-env/pkg/tlsconfig"
+	"github.com/google/go-cmp/cmp"
+)
+
+const httpSecretsDocument = `{
+	"data": {
+		"secrets": {"DB_PASSWORD": "pa33w0rd", "API_KEY": "top-secret-key-123"},
+		"a/b": {"SLASHED": "true"},
+		"list": [{"FIRST": "1"}]
+	},
+	"version": 3
+}`
+
+// fakeSecretsBroker serves the secrets document to a bearer token or a basic auth user
+func fakeSecretsBroker(token, username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		authorized := (token != "" && r.Header.Get("Authorization") == "Bearer "+token) ||
+			(username != "" && ok && user == username && pass == password)
+		if !authorized {
+			http.Error(w, `{"error": "unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, httpSecretsDocument)
+	})
+}
+
+func TestHTTPRetrieveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("broker-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pki := newTestPKI(t)
+	tlsServer := pki.newMutualTLSServer(t, fakeSecretsBroker("broker-token", "", ""))
+	basicServer := httptest.NewServer(fakeSecretsBroker("", "app", "basic-pa33w0rd"))
+	defer basicServer.Close()
+	mutualTLS := tlsconfig.Config{CACert: pki.CACert, ClientCert: pki.ClientCert, ClientKey: pki.ClientKey}
+
+	testCases := []struct {
+		name            string
+		cfg             httpjson.Config
+		wants           map[string]interface{}
+		wantsErr        error
+		wantsErrMessage string
+	}{
+		{
+			name: "bearer token file with mutual TLS",
+			cfg:  httpjson.Config{URL: tlsServer.URL, BearerTokenFile: tokenFile, Pointer: "/data/secrets", TLS: mutualTLS},
+			wants: map[string]interface{}{
+				"DB_PASSWORD": "pa33w0rd",
+				"API_KEY":     "top-secret-key-123",
+			},
+		},
+		{
+			name:  "basic auth with escaped pointer",
+			cfg:   httpjson.Config{URL: basicServer.URL, Username: "app", Password: "basic-pa33w0rd", Pointer: "/data/a~1b"},
+			wants: map[string]interface{}{"SLASHED": "true"},
+		},
+		{
+			name:  "pointer into an array",
+			cfg:   httpjson.Config{URL: basicServer.URL, Username: "app", Password: "basic-pa33w0rd", Pointer: "/data/list/0"},
+			wants: map[string]interface{}{"FIRST": "1"},
+		},
+		{
+			name:     "missing pointer",
+			cfg:      httpjson.Config{URL: basicServer.URL, Username: "app", Password: "basic-pa33w0rd", Pointer: "/data/missing"},
+			wantsErr: httpjson.ErrPointerNotFound,
+		},
+		{
+			name:            "pointer to a scalar",
+			cfg:             httpjson.Config{URL: basicServer.URL, Username: "app", Password: "basic-pa33w0rd", Pointer: "/version"},
+			wantsErrMessage: "is not an object",
+		},
+		{
+			name:            "wrong password",
+			cfg:             httpjson.Config{URL: basicServer.URL, Username: "app", Password: "wrong"},
+			wantsErrMessage: "status 401",
+		},
+		{
+			name:            "no client certificate",
+			cfg:             httpjson.Config{URL: tlsServer.URL, BearerTokenFile: tokenFile, TLS: tlsconfig.Config{CACert: pki.CACert}},
+			wantsErrMessage: "failed to get",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := testCase.cfg
+			client, err := httpjson.NewClient(&cfg)
+			if err != nil {
+				t.Fatalf("error creating HTTP client: %v", err)
+			}
+
+			secretData, err := httpjson.RetrieveSecret(client, &cfg)
+			switch {
+			case testCase.wantsErr != nil:
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+				return
+			case testCase.wantsErrMessage != "":
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kubernetes "github.com/doitintl/secrets-consumer-env/pkg/kubernetes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/magiconair/properties/assert"
+)
+
+const fakeServiceAccountToken = "fake-service-account-token"
+
+// newFakeKubernetesAPIServer serves the Secrets of its namespace/name keyed map, the service
+// account can only get the secrets in allowed namespaces
+func newFakeKubernetesAPIServer(secrets map[string]map[string][]byte, allowed map[string]bool) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		split := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+		if r.Header.Get("Authorization") != "Bearer "+fakeServiceAccountToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"kind": "Status", "message": "Unauthorized"}`)
+			return
+		}
+		if len(split) != 3 || split[1] != "secrets" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind": "Status", "message": "the server could not find the requested resource"}`)
+			return
+		}
+		namespace, name := split[0], split[2]
+		if !allowed[namespace] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"kind": "Status", "message": "secrets \"%s\" is forbidden: User \"system:serviceaccount:%s:app\" cannot get resource \"secrets\""}`, name, namespace)
+			return
+		}
+		data, ok := secrets[namespace+"/"+name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"kind": "Status", "message": "secrets \"%s\" not found"}`, name)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kind":     "Secret",
+			"metadata": map[string]string{"name": name, "namespace": namespace},
+			"data":     data,
+		})
+	}))
+}
+
+func TestKubernetesRetrieveSecret(t *testing.T) {
+	server := newFakeKubernetesAPIServer(map[string]map[string][]byte{
+		"default/app-config": {"API_KEY": []byte("top-secret-key-123"), "DEBUG": []byte("false")},
+		"default/overrides":  {"DEBUG": []byte("true")},
+		"shared/stripe":      {"STRIPE_KEY": []byte("sk_live_123")},
+		"other/db":           {"DB_PASSWORD": []byte("pa33w0rd")},
+	}, map[string]bool{"default": true, "shared": true})
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kubernetes-service-account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	caCertPath := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(tokenPath, []byte(fakeServiceAccountToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caCertPath, caCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		secretNames []string
+		wants       map[string]interface{}
+		wantsErr    error
+	}{
+		{
+			name:        "secrets in namespace order",
+			secretNames: []string{"app-config", "shared/stripe", "overrides"},
+			wants: map[string]interface{}{
+				"API_KEY":    "top-secret-key-123",
+				"DEBUG":      "true",
+				"STRIPE_KEY": "sk_live_123",
+			},
+		},
+		{
+			name:        "missing secret",
+			secretNames: []string{"app-config", "missing"},
+			wantsErr:    kubernetes.ErrSecretNotFound,
+		},
+		{
+			name:        "forbidden namespace",
+			secretNames: []string{"other/db"},
+			wantsErr:    kubernetes.ErrForbidden,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := &kubernetes.Config{
+				APIServer:   server.URL,
+				TokenPath:   tokenPath,
+				CACertPath:  caCertPath,
+				Namespace:   "default",
+				SecretNames: testCase.secretNames,
+			}
+			client, err := kubernetes.NewClient(cfg)
+			if err != nil {
+				t.Fatalf("error creating Kubernetes API client: %v", err)
+			}
+
+			secretData, err := kubernetes.RetrieveSecret(client, cfg)
+			if testCase.wantsErr != nil {
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+
+	// the API server certificate is not trusted without the CA certificate
+	client, err := kubernetes.NewClient(&kubernetes.Config{APIServer: server.URL, TokenPath: tokenPath})
+	if err != nil {
+		t.Fatalf("error creating Kubernetes API client: %v", err)
+	}
+	if _, err := kubernetes.RetrieveSecret(client, &kubernetes.Config{Namespace: "default", SecretNames: []string{"app-config"}}); err == nil {
+		t.Errorf("expected a certificate error without the CA certificate")
+	}
+}
+
+func TestKubernetesParseSecretRef(t *testing.T) {
+	ref, err := kubernetes.ParseSecretRef("app-config", "default")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ref, kubernetes.SecretRef{Namespace: "default", Name: "app-config"})
+
+	ref, err = kubernetes.ParseSecretRef("shared/stripe", "default")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ref, kubernetes.SecretRef{Namespace: "shared", Name: "stripe"})
+
+	for _, bad := range []string{"", "shared/", "/stripe", "a/b/c"} {
+		if _, err := kubernetes.ParseSecretRef(bad, "default"); err == nil {
+			t.Errorf("expected an error for secret reference %q", bad)
+		}
+	}
+}
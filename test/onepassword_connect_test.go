@@ -0,0 +1,173 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/onepassword"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeOnePasswordConnect is a stand-in for the 1Password Connect REST API
+type fakeOnePasswordConnect struct {
+	token  string
+	vaults map[string]string
+	items  map[string][]onepassword.Item
+}
+
+func (f *fakeOnePasswordConnect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"status": 401, "message": "Invalid token signature"}`)
+		return
+	}
+	// the filters are name eq "<name>" and title eq "<title>"
+	filterValue := func() string {
+		filter := r.URL.Query().Get("filter")
+		return strings.Trim(filter[strings.Index(filter, " eq ")+4:], `"`)
+	}
+
+	split := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/vaults"), "/")
+	switch {
+	case len(split) == 1:
+		var vaults []map[string]string
+		for id, name := range f.vaults {
+			if name == filterValue() {
+				vaults = append(vaults, map[string]string{"id": id, "name": name})
+			}
+		}
+		json.NewEncoder(w).Encode(vaults)
+	case len(split) == 3 && split[2] == "items":
+		items := []onepassword.Item{}
+		for _, item := range f.items[split[1]] {
+			if item.Title == filterValue() {
+				items = append(items, onepassword.Item{ID: item.ID, Title: item.Title})
+			}
+		}
+		json.NewEncoder(w).Encode(items)
+	case len(split) == 4 && split[2] == "items":
+		for _, item := range f.items[split[1]] {
+			if item.ID == split[3] {
+				json.NewEncoder(w).Encode(item)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"status": 404, "message": "item not found"}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestOnePasswordRetrieveSecret(t *testing.T) {
+	const prodVault, sharedVault = "prodvaultuuid0000000000000", "sharedvaultuuid00000000000"
+	server := httptest.NewServer(&fakeOnePasswordConnect{
+		token:  "connect-token",
+		vaults: map[string]string{prodVault: "Production", sharedVault: "Shared"},
+		items: map[string][]onepassword.Item{
+			prodVault: {
+				{ID: "dbitemuuid0000000000000000", Title: "Payments DB", Fields: []onepassword.Field{
+					{ID: "username", Label: "username", Purpose: "USERNAME", Value: "payments"},
+					{ID: "password", Label: "password", Purpose: "PASSWORD", Type: "CONCEALED", Value: "pa33w0rd"},
+					{ID: "notesPlain", Label: "notesPlain", Purpose: "NOTES"},
+					{ID: "host", Label: "db host", Value: "db.internal"},
+				}},
+				{ID: "dupitem1uuid00000000000000", Title: "Duplicate"},
+				{ID: "dupitem2uuid00000000000000", Title: "Duplicate"},
+			},
+			sharedVault: {
+				{ID: "apiitemuuid000000000000000", Title: "API", Fields: []onepassword.Field{
+					{ID: "key", Label: "api-key", Type: "CONCEALED", Value: "top-secret-key-123"},
+					{ID: "host", Label: "db host", Value: "db.shared"},
+				}},
+			},
+		},
+	})
+	defer server.Close()
+
+	testCases := []struct {
+		name            string
+		token           string
+		items           []string
+		wants           map[string]interface{}
+		wantsErr        error
+		wantsErrMessage string
+	}{
+		{
+			name:  "items by name and UUID",
+			token: "connect-token",
+			items: []string{"Production/Payments DB", "op://" + sharedVault + "/API"},
+			wants: map[string]interface{}{
+				"USERNAME": "payments",
+				"PASSWORD": "pa33w0rd",
+				"DB_HOST":  "db.shared",
+				"API_KEY":  "top-secret-key-123",
+			},
+		},
+		{
+			name:     "missing vault",
+			token:    "connect-token",
+			items:    []string{"Staging/Payments DB"},
+			wantsErr: onepassword.ErrVaultNotFound,
+		},
+		{
+			name:     "missing item",
+			token:    "connect-token",
+			items:    []string{"Production/Missing"},
+			wantsErr: onepassword.ErrItemNotFound,
+		},
+		{
+			name:            "ambiguous title",
+			token:           "connect-token",
+			items:           []string{"Production/Duplicate"},
+			wantsErrMessage: "2 items are titled Duplicate",
+		},
+		{
+			name:            "bad reference",
+			token:           "connect-token",
+			items:           []string{"Payments DB"},
+			wantsErrMessage: "bad item reference",
+		},
+		{
+			name:     "bad token",
+			token:    "other-token",
+			items:    []string{"Production/Payments DB"},
+			wantsErr: onepassword.ErrUnauthorized,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := &onepassword.Config{Host: server.URL, Token: testCase.token, Items: testCase.items}
+			client, err := onepassword.NewClient(cfg)
+			if err != nil {
+				t.Fatalf("error creating 1Password Connect client: %v", err)
+			}
+
+			secretData, err := onepassword.RetrieveSecret(client, cfg)
+			switch {
+			case testCase.wantsErr != nil:
+				if !errors.Is(err, testCase.wantsErr) {
+					t.Fatalf("expected error %v, got: %v", testCase.wantsErr, err)
+				}
+				return
+			case testCase.wantsErrMessage != "":
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("error retrieving secret data %v", err)
+			}
+			if !cmp.Equal(secretData, testCase.wants) {
+				t.Errorf("secretData = diff %v", cmp.Diff(secretData, testCase.wants))
+			}
+		})
+	}
+}
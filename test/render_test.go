@@ -0,0 +1,142 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/render"
+	"github.com/google/go-cmp/cmp"
+	"github.com/magiconair/properties/assert"
+	"gopkg.in/yaml.v2"
+)
+
+var renderVariables = render.ParseEnviron([]string{
+	"TLS_KEY=-----BEGIN KEY-----\nMIIB\n-----END KEY-----",
+	"API_KEY=qwe1234",
+	"QUOTED=it's a \"$HOME\" `cmd` \\ path",
+	"EMPTY=",
+	"API_KEY=overridden",
+})
+
+func TestRender(t *testing.T) {
+	testCases := []struct {
+		format string
+		wants  string
+	}{
+		{
+			format: render.FormatDotenv,
+			wants: "API_KEY=overridden\n" +
+				"EMPTY=\"\"\n" +
+				"QUOTED=\"it's a \\\"\\$HOME\\\" \\`cmd\\` \\\\ path\"\n" +
+				"TLS_KEY=\"-----BEGIN KEY-----\\nMIIB\\n-----END KEY-----\"\n",
+		},
+		{
+			format: render.FormatExport,
+			wants: "export API_KEY='overridden'\n" +
+				"export EMPTY=''\n" +
+				"export QUOTED='it'\\''s a \"$HOME\" `cmd` \\ path'\n" +
+				"export TLS_KEY='-----BEGIN KEY-----\nMIIB\n-----END KEY-----'\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := render.Render(&buf, testCase.format, renderVariables); err != nil {
+				t.Fatalf("error rendering %v", err)
+			}
+			assert.Equal(t, buf.String(), testCase.wants)
+		})
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	wants := map[string]string{}
+	for _, v := range renderVariables {
+		wants[v.Name] = v.Value
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := render.Render(&buf, render.FormatJSON, renderVariables); err != nil {
+			t.Fatalf("error rendering %v", err)
+		}
+		var got map[string]string
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("error decoding %v", err)
+		}
+		if !cmp.Equal(got, wants) {
+			t.Errorf("json = diff %v", cmp.Diff(got, wants))
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := render.Render(&buf, render.FormatYAML, renderVariables); err != nil {
+			t.Fatalf("error rendering %v", err)
+		}
+		var got map[string]string
+		if err := yaml.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("error decoding %v", err)
+		}
+		if !cmp.Equal(got, wants) {
+			t.Errorf("yaml = diff %v", cmp.Diff(got, wants))
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := render.Render(&buf, render.FormatExport, renderVariables); err != nil {
+			t.Fatalf("error rendering %v", err)
+		}
+		script := buf.String() + `printf '%s' "$TLS_KEY|$QUOTED|$API_KEY"`
+		out, err := exec.Command("sh", "-c", script).Output()
+		if err != nil {
+			t.Fatalf("error sourcing the export script %v", err)
+		}
+		assert.Equal(t, string(out), wants["TLS_KEY"]+"|"+wants["QUOTED"]+"|"+wants["API_KEY"])
+	})
+}
+
+func TestRenderWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "app.env")
+	if err := ioutil.WriteFile(path, []byte("OLD=value\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := render.WriteFile(path, render.FormatDotenv, []render.Variable{{Name: "API_KEY", Value: "qwe1234"}}); err != nil {
+		t.Fatalf("error writing file %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(data), "API_KEY=qwe1234\n")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, info.Mode().Perm(), render.FileMode)
+
+	// no temporary file is left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(files), 1)
+
+	if err := render.WriteFile(path, "xml", nil); err == nil {
+		t.Error("expected an unknown format error")
+	}
+}
@@ -1,6 +1,8 @@
 package test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/doitintl/secrets-consumer-env/pkg/injector"
@@ -74,9 +76,9 @@ func TestSecretInjector(t *testing.T) {
 				"COLORFGBG=15;0",
 				"XPC_FLAGS=0x0",
 				"api_key=qwe1234",
+				"bool=true",
 				"db_password=s3cr3t",
 				"int=8200",
-				"bool=true",
 			},
 		},
 	}
@@ -94,3 +96,735 @@ func TestSecretInjector(t *testing.T) {
 		})
 	}
 }
+
+func TestInjectSecretsReferences(t *testing.T) {
+	calls := map[string][][]string{}
+	resolvers := map[string]injector.Resolver{
+		"vault": func(paths []string) (map[string]interface{}, error) {
+			calls["vault"] = append(calls["vault"], paths)
+			return map[string]interface{}{
+				"secret/data/db":  map[string]interface{}{"password": "s3cr3t", "port": 5432},
+				"secret/data/api": map[string]interface{}{"key": "qwe1234"},
+				"secret/data/tls": map[string]interface{}{"key": "LS0tLS1CRUdJTiBLRVktLS0tLQ=="},
+			}, nil
+		},
+		"aws": func(names []string) (map[string]interface{}, error) {
+			calls["aws"] = append(calls["aws"], names)
+			return map[string]interface{}{"prod/db": `{"password": "aws-s3cr3t"}`}, nil
+		},
+		"gcp": func(names []string) (map[string]interface{}, error) {
+			calls["gcp"] = append(calls["gcp"], names)
+			return map[string]interface{}{"projects/p/secrets/db/versions/3": "gcp-s3cr3t"}, nil
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		environ         []string
+		secretData      map[string]interface{}
+		wants           []string
+		wantsCalls      map[string][][]string
+		noResolvers     bool
+		wantsErrMessage string
+	}{
+		{
+			name: "references batched per source",
+			environ: []string{
+				"PATH=/usr/bin:/bin",
+				"DB_PASS=vault:secret/data/db#password",
+				"DB_PORT=>>vault:secret/data/db#port",
+				"API_KEY=vault:secret/data/api#key",
+				"AWS_DB_PASS=aws:prod/db#password",
+				"GCP_DB_PASS=gcp:projects/p/secrets/db/versions/3",
+				"EXPLICIT=secret:api_key",
+			},
+			secretData: map[string]interface{}{"api_key": "from-data", "other": "not-exported"},
+			wants: []string{
+				"PATH=/usr/bin:/bin",
+				"DB_PASS=s3cr3t",
+				"DB_PORT=5432",
+				"API_KEY=qwe1234",
+				"AWS_DB_PASS=aws-s3cr3t",
+				"GCP_DB_PASS=gcp-s3cr3t",
+				"EXPLICIT=from-data",
+			},
+			wantsCalls: map[string][][]string{
+				"vault": {{"secret/data/api", "secret/data/db"}},
+				"aws":   {{"prod/db"}},
+				"gcp":   {{"projects/p/secrets/db/versions/3"}},
+			},
+		},
+		{
+			name:       "no references, no resolvers called",
+			environ:    []string{"DB_PASS=vault:db_password"},
+			secretData: map[string]interface{}{"db_password": "s3cr3t"},
+			wants:      []string{"DB_PASS=s3cr3t"},
+			wantsCalls: map[string][][]string{},
+		},
+		{
+			name: "references and key lookups",
+			environ: []string{
+				"DB_PASS=vault:secret/data/db#password",
+				"AWS_DB_PASS=aws:prod/db#password",
+				"GCP_DB_PASS=gcp:projects/p/secrets/db/versions/3",
+				"API_KEY=vault:api_key",
+				"DEBUG=secret:debug",
+				"NOTE=aws:this is a note",
+			},
+			secretData: map[string]interface{}{"api_key": "from-data", "debug": "true"},
+			wants: []string{
+				"DB_PASS=s3cr3t",
+				"AWS_DB_PASS=aws-s3cr3t",
+				"GCP_DB_PASS=gcp-s3cr3t",
+				"API_KEY=from-data",
+				"DEBUG=true",
+				"NOTE=aws:this is a note",
+			},
+			wantsCalls: map[string][][]string{
+				"vault": {{"secret/data/db"}},
+				"aws":   {{"prod/db"}},
+				"gcp":   {{"projects/p/secrets/db/versions/3"}},
+			},
+		},
+		{
+			name:            "reference without a secret path",
+			environ:         []string{"DB_PASS=vault:db#password"},
+			wantsErrMessage: "vault:db#password is not a valid vault reference",
+		},
+		{
+			name:            "gcp reference without a secret",
+			environ:         []string{"DB_PASS=gcp:projects/p/db#password|trim"},
+			wantsErrMessage: "gcp:projects/p/db#password is not a valid gcp reference",
+		},
+		{
+			name:            "url style reference",
+			environ:         []string{"DB_PASS=aws://prod/db#password"},
+			wantsErrMessage: "aws://prod/db#password is not a valid aws reference",
+		},
+		{
+			name: "references with transforms",
+			environ: []string{
+				"TLS_KEY=vault:secret/data/tls#key|base64decode",
+				"DB_PASS=>>vault:secret/data/db|jsonpath:$['password']|base64encode",
+				"GCP_DB_PASS=gcp:projects/p/secrets/db/versions/3|trim",
+			},
+			wants: []string{
+				"TLS_KEY=-----BEGIN KEY-----",
+				"DB_PASS=czNjcjN0",
+				"GCP_DB_PASS=gcp-s3cr3t",
+			},
+			wantsCalls: map[string][][]string{
+				"vault": {{"secret/data/db", "secret/data/tls"}},
+				"gcp":   {{"projects/p/secrets/db/versions/3"}},
+			},
+		},
+		{
+			name:            "reference with an unknown transform",
+			environ:         []string{"DB_PASS=vault:secret/data/db#password|upper"},
+			wantsErrMessage: `Reference vault:secret/data/db#password has an unknown transform "upper"`,
+		},
+		{
+			name:            "reference with a failed transform",
+			environ:         []string{"DB_PASS=vault:secret/data/db#password|jsonpath:$.db"},
+			wantsErrMessage: "Reference vault:secret/data/db#password transform jsonpath:$.db failed: the value is not JSON",
+		},
+		{
+			name:            "missing key",
+			environ:         []string{"DB_PASS=vault:secret/data/db#user"},
+			wantsErrMessage: "key user not found",
+		},
+		{
+			name:            "key of a plain text secret",
+			environ:         []string{"DB_PASS=gcp:projects/p/secrets/db/versions/3#password"},
+			wantsErrMessage: "the secret is not a JSON object",
+		},
+		{
+			name:            "source without a resolver",
+			environ:         []string{"DB_PASS=vault:secret/data/db#password"},
+			noResolvers:     true,
+			wantsErrMessage: "no resolver for vault references",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			calls = map[string][][]string{}
+			opts := &injector.Options{Resolvers: resolvers}
+			if testCase.noResolvers {
+				opts.Resolvers = nil
+			}
+			sanitized, err := injector.InjectSecretsWithOptions(testCase.secretData, testCase.environ, make(injector.SanitizedEnviron, 0, len(testCase.environ)), opts)
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error runing test %s, %v", testCase.name, err)
+			}
+			if !cmp.Equal(sanitized, testCase.wants) {
+				t.Errorf("sanitized = diff %v", cmp.Diff(sanitized, testCase.wants))
+			}
+			if !cmp.Equal(calls, testCase.wantsCalls) {
+				t.Errorf("resolver calls = diff %v", cmp.Diff(calls, testCase.wantsCalls))
+			}
+		})
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	testCases := []struct {
+		value   string
+		wants   injector.Reference
+		wantsOk bool
+	}{
+		{value: "vault:secret/data/db#password", wants: injector.Reference{Source: "vault", Path: "secret/data/db", Key: "password"}, wantsOk: true},
+		{value: "aws:prod/db#password", wants: injector.Reference{Source: "aws", Path: "prod/db", Key: "password"}, wantsOk: true},
+		{value: "aws:db#password", wants: injector.Reference{Source: "aws", Path: "db", Key: "password"}, wantsOk: true},
+		{value: "gcp:projects/p/secrets/db/versions/3", wants: injector.Reference{Source: "gcp", Path: "projects/p/secrets/db/versions/3"}, wantsOk: true},
+		{value: "aws:arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf#password", wants: injector.Reference{Source: "aws", Path: "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf", Key: "password"}, wantsOk: true},
+		{value: "vault:db_password"},
+		{value: "aws:db"},
+		{value: "vault:secret/data/db#password|trim"},
+		{value: "secret:secret/data/db#password"},
+		{value: "gcp:db"},
+		{value: "vault:db#password"},
+		{value: "vault://secret/data/db#password"},
+		{value: "aws:this is a note"},
+		{value: "aws:prod/db#"},
+		{value: "gcp:projects/p/logs"},
+		{value: "https://example.com/path"},
+		{value: "/usr/bin:/bin"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.value, func(t *testing.T) {
+			ref, ok := injector.ParseReference(testCase.value)
+			if ok != testCase.wantsOk {
+				t.Fatalf("ParseReference(%q) ok = %v, want %v", testCase.value, ok, testCase.wantsOk)
+			}
+			if ok && ref != testCase.wants {
+				t.Errorf("reference = diff %v", cmp.Diff(ref, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestPlanInjections(t *testing.T) {
+	resolvers := map[string]injector.Resolver{
+		"gcp": func(names []string) (map[string]interface{}, error) {
+			return map[string]interface{}{"projects/p/secrets/db/versions/3": `{"password": "gcp-s3cr3t"}`}, nil
+		},
+		"aws": func(names []string) (map[string]interface{}, error) {
+			return nil, errors.New("AccessDeniedException")
+		},
+	}
+
+	type row struct {
+		Name, Source, Version string
+		Length                int
+		Failed                bool
+	}
+	testCases := []struct {
+		name            string
+		environ         []string
+		secretData      map[string]interface{}
+		opts            injector.Options
+		wants           []row
+		wantsErrMessage string
+	}{
+		{
+			name:    "every missing key and failed reference is reported",
+			environ: []string{"PATH=/usr/bin", "VAULT_ROLE=app", "API_KEY=secret:api_key", "DB_PASSWORD=vault:db_password", "GCP_DB=gcp:projects/p/secrets/db/versions/3#password", "AWS_DB=aws:prod/db#password"},
+			secretData: map[string]interface{}{
+				"api_key": "qwe1234",
+			},
+			wants: []row{
+				{Name: "API_KEY", Source: "vault:api_key", Version: "-", Length: 7},
+				{Name: "DB_PASSWORD", Source: "vault:db_password", Version: "-", Failed: true},
+				{Name: "GCP_DB", Source: "gcp:projects/p/secrets/db/versions/3#password", Version: "3", Length: 10},
+				{Name: "AWS_DB", Source: "aws:prod/db#password", Version: "AWSCURRENT", Failed: true},
+			},
+		},
+		{
+			name:    "all secret keys sorted",
+			environ: []string{"PATH=/usr/bin"},
+			secretData: map[string]interface{}{
+				"db_password": "s3cr3t",
+				"api_key":     "qwe1234",
+				"VAULT_TOKEN": "s.token",
+			},
+			wants: []row{
+				{Name: "api_key", Source: "vault:api_key", Version: "-", Length: 7},
+				{Name: "db_password", Source: "vault:db_password", Version: "-", Length: 6},
+			},
+		},
+		{
+			name:    "provider versions",
+			environ: []string{"PATH=/usr/bin"},
+			secretData: map[string]interface{}{
+				"db_password": "s3cr3t",
+				"api_key":     "qwe1234",
+			},
+			opts: injector.Options{Versions: map[string]string{"db_password": "7"}},
+			wants: []row{
+				{Name: "api_key", Source: "vault:api_key", Version: "-", Length: 7},
+				{Name: "db_password", Source: "vault:db_password", Version: "7", Length: 6},
+			},
+		},
+		{
+			name:    "secret keys already set with the error precedence",
+			environ: []string{"PATH=/usr/bin", "API_KEY=from-env"},
+			secretData: map[string]interface{}{
+				"API_KEY":     "qwe1234",
+				"DB_PASSWORD": "s3cr3t",
+			},
+			opts: injector.Options{Precedence: injector.PrecedenceError},
+			wants: []row{
+				{Name: "API_KEY", Source: "vault:API_KEY", Version: "-", Length: 7, Failed: true},
+				{Name: "DB_PASSWORD", Source: "vault:DB_PASSWORD", Version: "-", Length: 6},
+			},
+		},
+		{
+			name:    "strict violations",
+			environ: []string{"PATH=/usr/bin"},
+			secretData: map[string]interface{}{
+				"db-password": "s3cr3t",
+			},
+			opts:            injector.Options{Strict: true},
+			wants:           []row{{Name: "db-password", Source: "vault:db-password", Version: "-", Length: 6}},
+			wantsErrMessage: `secret key "db-password" is not a valid env var name`,
+		},
+		{
+			name:            "invalid options",
+			environ:         []string{"PATH=/usr/bin"},
+			secretData:      map[string]interface{}{"api_key": "qwe1234"},
+			opts:            injector.Options{Precedence: "first-wins"},
+			wantsErrMessage: "first-wins",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			opts := testCase.opts
+			opts.Resolvers = resolvers
+			injections, err := injector.PlanInjections(testCase.secretData, testCase.environ, "vault", &opts)
+			switch {
+			case testCase.wantsErrMessage != "":
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+			case err != nil:
+				t.Fatalf("error planning the injections %v", err)
+			}
+
+			var rows []row
+			for _, injection := range injections {
+				rows = append(rows, row{
+					Name:    injection.Name,
+					Source:  injection.Source,
+					Version: injection.Version,
+					Length:  len(injection.Value),
+					Failed:  injection.Err != nil,
+				})
+			}
+			if !cmp.Equal(rows, testCase.wants) {
+				t.Errorf("injections = diff %v", cmp.Diff(rows, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestInjectSecretsPrecedence(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"API_KEY=from-env",
+		"DEBUG=false",
+		"DEBUG=true",
+	}
+	secretData := map[string]interface{}{
+		"DB_PASSWORD": "s3cr3t",
+		"API_KEY":     "from-secret",
+	}
+
+	testCases := []struct {
+		precedence      string
+		wants           []string
+		wantsErrMessage string
+	}{
+		{
+			precedence: "",
+			wants:      []string{"PATH=/usr/bin", "API_KEY=from-secret", "DEBUG=true", "DB_PASSWORD=s3cr3t"},
+		},
+		{
+			precedence: injector.PrecedenceSecretsWin,
+			wants:      []string{"PATH=/usr/bin", "API_KEY=from-secret", "DEBUG=true", "DB_PASSWORD=s3cr3t"},
+		},
+		{
+			precedence: injector.PrecedenceEnvWins,
+			wants:      []string{"PATH=/usr/bin", "API_KEY=from-env", "DEBUG=true", "DB_PASSWORD=s3cr3t"},
+		},
+		{
+			precedence:      injector.PrecedenceError,
+			wantsErrMessage: "secret keys are already set in the environment: API_KEY",
+		},
+		{
+			precedence:      "last-wins",
+			wantsErrMessage: "unknown precedence",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.precedence, func(t *testing.T) {
+			sanitized, err := injector.InjectSecretsWithOptions(secretData, environ, injector.SanitizedEnviron{}, &injector.Options{Precedence: testCase.precedence})
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error injecting secrets %v", err)
+			}
+			if !cmp.Equal(sanitized, testCase.wants) {
+				t.Errorf("sanitized = diff %v", cmp.Diff(sanitized, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestInjectSecretsIncludeExclude(t *testing.T) {
+	environ := []string{"PATH=/usr/bin"}
+	secretData := map[string]interface{}{
+		"DB_USER":           "admin",
+		"DB_PASSWORD":       "s3cr3t",
+		"DB_ADMIN_PASSWORD": "r00t",
+		"API_KEY":           "qwe1234",
+	}
+
+	testCases := []struct {
+		name            string
+		environ         []string
+		include         []string
+		exclude         []string
+		wants           []string
+		wantsErrMessage string
+	}{
+		{
+			name:  "every key without patterns",
+			wants: []string{"PATH=/usr/bin", "API_KEY=qwe1234", "DB_ADMIN_PASSWORD=r00t", "DB_PASSWORD=s3cr3t", "DB_USER=admin"},
+		},
+		{
+			name:    "included keys",
+			include: []string{"DB_*"},
+			wants:   []string{"PATH=/usr/bin", "DB_ADMIN_PASSWORD=r00t", "DB_PASSWORD=s3cr3t", "DB_USER=admin"},
+		},
+		{
+			name:    "excluded keys",
+			exclude: []string{"*ADMIN*", "API_KEY"},
+			wants:   []string{"PATH=/usr/bin", "DB_PASSWORD=s3cr3t", "DB_USER=admin"},
+		},
+		{
+			name:    "exclude wins over include",
+			include: []string{"DB_*", "API_KEY"},
+			exclude: []string{"DB_ADMIN_*"},
+			wants:   []string{"PATH=/usr/bin", "API_KEY=qwe1234", "DB_PASSWORD=s3cr3t", "DB_USER=admin"},
+		},
+		{
+			name:    "explicit keys are not filtered",
+			environ: []string{"PATH=/usr/bin", "ROOT_PASSWORD=secret:DB_ADMIN_PASSWORD"},
+			exclude: []string{"DB_*"},
+			wants:   []string{"PATH=/usr/bin", "ROOT_PASSWORD=r00t"},
+		},
+		{
+			name:            "bad pattern",
+			include:         []string{"DB_["},
+			wantsErrMessage: `bad key pattern "DB_["`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			env := environ
+			if testCase.environ != nil {
+				env = testCase.environ
+			}
+			opts := &injector.Options{Include: testCase.include, Exclude: testCase.exclude}
+			sanitized, err := injector.InjectSecretsWithOptions(secretData, env, injector.SanitizedEnviron{}, opts)
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error injecting secrets %v", err)
+			}
+			if !cmp.Equal(sanitized, testCase.wants) {
+				t.Errorf("sanitized = diff %v", cmp.Diff(sanitized, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestInjectSecretsScrub(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"HOME=/home/app",
+		"DEBUG=true",
+		"VAULT_ROLE=app",
+		"AWS_ACCESS_KEY_ID=AKIA",
+		"ROLE_ARN=arn:aws:iam::123456789012:role/app",
+		"GOOGLE_APPLICATION_CREDENTIALS=/creds.json",
+		"CI_JOB_TOKEN=t0k3n",
+		"SECRETS_PRECEDENCE=env-wins",
+	}
+	secretData := map[string]interface{}{
+		"DB_PASSWORD":       "s3cr3t",
+		"VAULT_TOKEN":       "s.token",
+		"PROJECT_ID":        "checkout-prod",
+		"AWS_ACCESS_KEY_ID": "AKIA-APP",
+		"CI_JOB_TOKEN":      "app-t0k3n",
+	}
+
+	testCases := []struct {
+		name    string
+		environ []string
+		opts    injector.Options
+		wants   []string
+	}{
+		{
+			name: "no provider",
+			wants: []string{
+				"PATH=/usr/bin",
+				"HOME=/home/app",
+				"DEBUG=true",
+				"AWS_ACCESS_KEY_ID=AKIA-APP",
+				"ROLE_ARN=arn:aws:iam::123456789012:role/app",
+				"GOOGLE_APPLICATION_CREDENTIALS=/creds.json",
+				"CI_JOB_TOKEN=app-t0k3n",
+				"DB_PASSWORD=s3cr3t",
+				"PROJECT_ID=checkout-prod",
+			},
+		},
+		{
+			name: "aws provider",
+			opts: injector.Options{Providers: []string{"aws"}},
+			wants: []string{
+				"PATH=/usr/bin",
+				"HOME=/home/app",
+				"DEBUG=true",
+				"GOOGLE_APPLICATION_CREDENTIALS=/creds.json",
+				"CI_JOB_TOKEN=app-t0k3n",
+				"AWS_ACCESS_KEY_ID=AKIA-APP",
+				"DB_PASSWORD=s3cr3t",
+				"PROJECT_ID=checkout-prod",
+			},
+		},
+		{
+			name: "user patterns",
+			opts: injector.Options{Providers: []string{"vault"}, Scrub: []string{"*_TOKEN", "DEBUG"}},
+			wants: []string{
+				"PATH=/usr/bin",
+				"HOME=/home/app",
+				"AWS_ACCESS_KEY_ID=AKIA-APP",
+				"ROLE_ARN=arn:aws:iam::123456789012:role/app",
+				"CI_JOB_TOKEN=app-t0k3n",
+				"DB_PASSWORD=s3cr3t",
+				"PROJECT_ID=checkout-prod",
+			},
+		},
+		{
+			name: "clean env with passthrough",
+			opts: injector.Options{CleanEnv: true, Passthrough: []string{"DEBUG", "AWS_*"}},
+			wants: []string{
+				"PATH=/usr/bin",
+				"HOME=/home/app",
+				"DEBUG=true",
+				"AWS_ACCESS_KEY_ID=AKIA-APP",
+				"CI_JOB_TOKEN=app-t0k3n",
+				"DB_PASSWORD=s3cr3t",
+				"PROJECT_ID=checkout-prod",
+			},
+		},
+		{
+			name:    "explicit keys",
+			environ: []string{"PATH=/usr/bin", "VAULT_ROLE=app", "VAULT_TOKEN=secret:VAULT_TOKEN", "CI_JOB_TOKEN=secret:CI_JOB_TOKEN"},
+			opts:    injector.Options{Providers: []string{"vault"}, Scrub: []string{"*_TOKEN"}},
+			wants:   []string{"PATH=/usr/bin", "CI_JOB_TOKEN=app-t0k3n"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			env := environ
+			if testCase.environ != nil {
+				env = testCase.environ
+			}
+			sanitized, err := injector.InjectSecretsWithOptions(secretData, env, injector.SanitizedEnviron{}, &testCase.opts)
+			if err != nil {
+				t.Fatalf("error injecting secrets %v", err)
+			}
+			if !cmp.Equal(sanitized, testCase.wants) {
+				t.Errorf("sanitized = diff %v", cmp.Diff(sanitized, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestInjectSecretsTransforms(t *testing.T) {
+	secretData := map[string]interface{}{
+		"tls_key":  "LS0tLS1CRUdJTiBLRVktLS0tLQ==",
+		"unpadded": "czNjcjN0",
+		"config":   `{"db": {"password": " s3cr3t\n", "hosts": ["db-0", "db-1"], "pool": {"size": 5}}, "pipes": {"a|b": "piped", "c]|d": "quoted"}}`,
+		"nested":   map[string]interface{}{"db": map[string]interface{}{"user": "admin"}},
+		"plain":    "  padded  ",
+	}
+
+	testCases := []struct {
+		name            string
+		value           string
+		wants           string
+		wantsErrMessage string
+	}{
+		{name: "base64decode", value: "secret:tls_key|base64decode", wants: "-----BEGIN KEY-----"},
+		{name: "unpadded base64decode", value: "vault:unpadded|base64decode", wants: "s3cr3t"},
+		{name: "base64encode", value: "secret:plain|trim|base64encode", wants: "cGFkZGVk"},
+		{name: "jsonpath and trim", value: "secret:config|jsonpath:$.db.password|trim", wants: "s3cr3t"},
+		{name: "jsonpath index", value: "secret:config|jsonpath:$.db.hosts[1]", wants: "db-1"},
+		{name: "jsonpath quoted key", value: "secret:config|jsonpath:$['db'].pool", wants: `{"size":5}`},
+		{name: "jsonpath key with a pipe", value: "secret:config|jsonpath:$.pipes['a|b']|trim", wants: "piped"},
+		{name: "jsonpath quoted key with a bracket and a pipe", value: `secret:config|jsonpath:$.pipes["c]|d"]`, wants: "quoted"},
+		{name: "jsonpath number", value: "secret:config|jsonpath:$.db.pool.size", wants: "5"},
+		{name: "jsonpath of a nested secret", value: "secret:nested|jsonpath:$.db.user", wants: "admin"},
+		{name: "trim", value: "secret:plain|trim", wants: "padded"},
+		{name: "unknown transform", value: "secret:plain|upper", wantsErrMessage: `Explicit key: plain has an unknown transform "upper"`},
+		{name: "bad base64", value: "secret:config|base64decode", wantsErrMessage: "Explicit key: config transform base64decode failed: the value is not base64 encoded"},
+		{name: "not JSON", value: "secret:plain|jsonpath:$.db", wantsErrMessage: "transform jsonpath:$.db failed: the value is not JSON"},
+		{name: "missing JSON key", value: "secret:config|jsonpath:$.db.user", wantsErrMessage: `path "$.db.user": key user not found`},
+		{name: "index out of range", value: "secret:config|jsonpath:$.db.hosts[2]", wantsErrMessage: "index 2 out of range"},
+		{name: "missing key", value: "secret:missing|trim", wantsErrMessage: "Explicit key: missing not found in secrets keys"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			environ := []string{"PATH=/usr/bin", "VALUE=" + testCase.value}
+			sanitized, err := injector.InjectSecrets(secretData, environ, injector.SanitizedEnviron{})
+			if testCase.wantsErrMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantsErrMessage) {
+					t.Fatalf("expected error containing %q, got: %v", testCase.wantsErrMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error injecting secrets %v", err)
+			}
+			wants := []string{"PATH=/usr/bin", "VALUE=" + testCase.wants}
+			if !cmp.Equal(sanitized, wants) {
+				t.Errorf("sanitized = diff %v", cmp.Diff(sanitized, wants))
+			}
+		})
+	}
+}
+
+func TestInjectSecretsValueFormatting(t *testing.T) {
+	secretData := map[string]interface{}{
+		"DB": map[string]interface{}{
+			"USER":  "admin",
+			"PORT":  float64(5432),
+			"HOSTS": []interface{}{"db-0", "db-1"},
+			"POOL":  map[interface{}]interface{}{"SIZE": 5},
+		},
+		"MAX_CONNECTIONS": float64(1000000),
+		"RATIO":           0.25,
+		"ENABLED":         true,
+		"EMPTY":           nil,
+	}
+
+	testCases := []struct {
+		name    string
+		environ []string
+		flatten bool
+		wants   []string
+	}{
+		{
+			name: "nested values as JSON",
+			wants: []string{
+				`DB={"HOSTS":["db-0","db-1"],"POOL":{"SIZE":5},"PORT":5432,"USER":"admin"}`,
+				"EMPTY=",
+				"ENABLED=true",
+				"MAX_CONNECTIONS=1000000",
+				"RATIO=0.25",
+			},
+		},
+		{
+			name:    "flattened nested values",
+			flatten: true,
+			wants: []string{
+				`DB_HOSTS=["db-0","db-1"]`,
+				"DB_POOL_SIZE=5",
+				"DB_PORT=5432",
+				"DB_USER=admin",
+				"EMPTY=",
+				"ENABLED=true",
+				"MAX_CONNECTIONS=1000000",
+				"RATIO=0.25",
+			},
+		},
+		{
+			name:    "explicit flattened and nested keys",
+			environ: []string{"USER=secret:DB_USER", "DB=secret:DB|jsonpath:$.PORT", "MAX=vault:MAX_CONNECTIONS"},
+			flatten: true,
+			wants:   []string{"USER=admin", "DB=5432", "MAX=1000000"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sanitized, err := injector.InjectSecretsWithOptions(secretData, testCase.environ, injector.SanitizedEnviron{}, &injector.Options{Flatten: testCase.flatten})
+			if err != nil {
+				t.Fatalf("error injecting secrets %v", err)
+			}
+			if !cmp.Equal(sanitized, testCase.wants) {
+				t.Errorf("sanitized = diff %v", cmp.Diff(sanitized, testCase.wants))
+			}
+		})
+	}
+}
+
+func TestInjectSecretsFlattenCollisions(t *testing.T) {
+	secretData := map[string]interface{}{
+		"DB":      map[string]interface{}{"USER": "nested", "POOL_SIZE": 5},
+		"DB_POOL": map[string]interface{}{"SIZE": 10},
+		"DB_USER": "literal",
+	}
+
+	testCases := []struct {
+		name    string
+		environ []string
+		wants   []string
+	}{
+		{
+			name:  "literal keys win when every key is exported",
+			wants: []string{"DB_POOL_SIZE=5", "DB_USER=literal"},
+		},
+		{
+			name:    "explicit keys get the exported values",
+			environ: []string{"USER=secret:DB_USER", "POOL=vault:DB_POOL_SIZE"},
+			wants:   []string{"USER=literal", "POOL=5"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sanitized, err := injector.InjectSecretsWithOptions(secretData, testCase.environ, injector.SanitizedEnviron{}, &injector.Options{Flatten: true})
+			if err != nil {
+				t.Fatalf("error injecting secrets %v", err)
+			}
+			if !cmp.Equal(sanitized, testCase.wants) {
+				t.Errorf("sanitized = diff %v", cmp.Diff(sanitized, testCase.wants))
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doitintl/secrets-consumer-env/pkg/injector"
+	"github.com/doitintl/secrets-consumer-env/pkg/merge"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergerConflicts(t *testing.T) {
+	merger := merge.NewMerger()
+	merger.Add("secret/app", map[string]interface{}{"API_KEY": "old", "DB_PASSWORD": "s3cr3t"})
+	merger.Add("secret/shared", map[string]interface{}{"API_KEY": "shared", "DEBUG": "true"})
+	merger.Add("secret/override", map[string]interface{}{"API_KEY": "new"})
+
+	wantsData := map[string]interface{}{"API_KEY": "new", "DB_PASSWORD": "s3cr3t", "DEBUG": "true"}
+	if !cmp.Equal(merger.Data, wantsData) {
+		t.Errorf("data = diff %v", cmp.Diff(merger.Data, wantsData))
+	}
+	wantsConflicts := []merge.Conflict{{Key: "API_KEY", Sources: []string{"secret/app", "secret/shared", "secret/override"}}}
+	if !cmp.Equal(merger.Conflicts(), wantsConflicts) {
+		t.Errorf("conflicts = diff %v", cmp.Diff(merger.Conflicts(), wantsConflicts))
+	}
+}
+
+func TestMergeRecorder(t *testing.T) {
+	first, second := &merge.Recorder{}, &merge.Recorder{}
+	merger := merge.NewMerger()
+	merger.Add("secret/app", map[string]interface{}{"API_KEY": "old"})
+	merger.Add("secret/override", map[string]interface{}{"API_KEY": "new", "DEBUG": "true"})
+	merger.SetVersion("secret/app", "3")
+	merger.SetVersion("secret/override", "7")
+	merger.Result(first)
+
+	wantsConflicts := []merge.Conflict{{Key: "API_KEY", Sources: []string{"secret/app", "secret/override"}}}
+	if !cmp.Equal(first.Conflicts(), wantsConflicts) {
+		t.Errorf("conflicts = diff %v", cmp.Diff(first.Conflicts(), wantsConflicts))
+	}
+	// the version of a key is the one of the last secret setting it
+	wantsVersions := map[string]string{"API_KEY": "7", "DEBUG": "7"}
+	if !cmp.Equal(first.Versions(), wantsVersions) {
+		t.Errorf("versions = diff %v", cmp.Diff(first.Versions(), wantsVersions))
+	}
+	if conflicts := second.Conflicts(); len(conflicts) != 0 {
+		t.Errorf("expected no conflicts in another recorder, got: %v", conflicts)
+	}
+	if versions := second.Versions(); len(versions) != 0 {
+		t.Errorf("expected no versions in another recorder, got: %v", versions)
+	}
+	// a nil recorder discards the conflicts
+	merger.Result(nil)
+}
+
+func TestMergeFetch(t *testing.T) {
+	sources := []string{"first", "second", "third"}
+	merger, err := merge.Fetch(sources, func(i int) (map[string]interface{}, error) {
+		return map[string]interface{}{"KEY": sources[i], sources[i]: "true"}, nil
+	})
+	if err != nil {
+		t.Fatalf("error fetching secrets %v", err)
+	}
+	wantsData := map[string]interface{}{"KEY": "third", "first": "true", "second": "true", "third": "true"}
+	if !cmp.Equal(merger.Data, wantsData) {
+		t.Errorf("data = diff %v", cmp.Diff(merger.Data, wantsData))
+	}
+
+	_, err = merge.Fetch(sources, func(i int) (map[string]interface{}, error) {
+		if i > 0 {
+			return nil, fmt.Errorf("error fetching %s", sources[i])
+		}
+		return map[string]interface{}{}, nil
+	})
+	if err == nil || err.Error() != "error fetching second" {
+		t.Errorf("expected the error of the second source, got: %v", err)
+	}
+}
+
+func TestMergeFetchWorkers(t *testing.T) {
+	testCases := []struct {
+		name       string
+		workers    int
+		wantsLimit int
+	}{
+		{name: "workers limit", workers: 4, wantsLimit: 4},
+		{name: "default workers", wantsLimit: merge.DefaultFetchWorkers},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sources := make([]string, 50)
+			for i := range sources {
+				sources[i] = fmt.Sprintf("secret-%d", i)
+			}
+			var mu sync.Mutex
+			running, maxRunning := 0, 0
+			merger, err := merge.FetchWithWorkers(sources, testCase.workers, func(i int) (map[string]interface{}, error) {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return map[string]interface{}{sources[i]: "true"}, nil
+			})
+			if err != nil {
+				t.Fatalf("error fetching secrets %v", err)
+			}
+			if len(merger.Data) != len(sources) {
+				t.Errorf("expected %d keys, got %d", len(sources), len(merger.Data))
+			}
+			if maxRunning > testCase.wantsLimit {
+				t.Errorf("expected at most %d sources fetched at a time, got %d", testCase.wantsLimit, maxRunning)
+			}
+			if maxRunning < 2 {
+				t.Errorf("expected the sources to be fetched concurrently, got %d at a time", maxRunning)
+			}
+		})
+	}
+}
+
+func TestInjectSecretsStrict(t *testing.T) {
+	testCases := []struct {
+		name           string
+		environ        []string
+		secrets        []map[string]interface{}
+		strict         bool
+		flatten        bool
+		wantsViolation []string
+	}{
+		{
+			name:    "every violation is reported",
+			environ: []string{"PATH=/usr/bin", "HOME=/root"},
+			secrets: []map[string]interface{}{
+				{"API_KEY": "old", "HOME": "/secret", "bad-key": "1"},
+				{"API_KEY": "new", "1ST": "2"},
+			},
+			strict: true,
+			wantsViolation: []string{
+				"key API_KEY is set by config-0, config-1",
+				`secret key "1ST" is not a valid env var name`,
+				"secret key HOME collides with the env var HOME",
+				`secret key "bad-key" is not a valid env var name`,
+			},
+		},
+		{
+			name:    "explicit keys only check the configs",
+			environ: []string{"HOME=/root", "API_KEY=secret:API_KEY"},
+			secrets: []map[string]interface{}{
+				{"HOME": "/secret", "bad-key": "1", "API_KEY": "qwe1234"},
+			},
+			strict: true,
+		},
+		{
+			name: "flattened keys collisions",
+			secrets: []map[string]interface{}{
+				{"DB": map[string]interface{}{"USER": "admin", "POOL": map[string]interface{}{"SIZE": 5}}, "DB_USER": "root", "DB_POOL_SIZE": 10},
+			},
+			strict:  true,
+			flatten: true,
+			wantsViolation: []string{
+				"key DB_POOL_SIZE is set by DB.POOL.SIZE, DB_POOL_SIZE",
+				"key DB_USER is set by DB.USER, DB_USER",
+			},
+		},
+		{
+			name:    "flattened keys collisions with explicit keys",
+			environ: []string{"USER=secret:DB_USER"},
+			secrets: []map[string]interface{}{
+				{"DB": map[string]interface{}{"USER": "admin"}, "DB_USER": "root"},
+			},
+			strict:         true,
+			flatten:        true,
+			wantsViolation: []string{"key DB_USER is set by DB.USER, DB_USER"},
+		},
+		{
+			name: "flattened keys collisions not strict",
+			secrets: []map[string]interface{}{
+				{"DB": map[string]interface{}{"USER": "admin"}, "DB_USER": "root"},
+			},
+			flatten: true,
+		},
+		{
+			name:    "not strict",
+			environ: []string{"HOME=/root"},
+			secrets: []map[string]interface{}{
+				{"API_KEY": "old", "HOME": "/secret"},
+				{"API_KEY": "new"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			recorder := &merge.Recorder{}
+			merger := merge.NewMerger()
+			for i, secret := range testCase.secrets {
+				merger.Add(fmt.Sprintf("config-%d", i), secret)
+			}
+			secretData := merger.Result(recorder)
+
+			opts := &injector.Options{Strict: testCase.strict, Flatten: testCase.flatten, Conflicts: recorder.Conflicts()}
+			_, err := injector.InjectSecretsWithOptions(secretData, testCase.environ, injector.SanitizedEnviron{}, opts)
+			if len(testCase.wantsViolation) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			var strictErr *injector.StrictError
+			if !errors.As(err, &strictErr) {
+				t.Fatalf("expected a strict error, got: %v", err)
+			}
+			if !cmp.Equal(strictErr.Violations, testCase.wantsViolation) {
+				t.Errorf("violations = diff %v", cmp.Diff(strictErr.Violations, testCase.wantsViolation))
+			}
+		})
+	}
+}
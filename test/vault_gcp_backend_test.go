@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	gcpSecretsManager "github.com/doitintl/secrets-consumer-env/pkg/gcp"
+	vault "github.com/doitintl/secrets-consumer-env/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/magiconair/properties/assert"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+)
+
+// newFakeVaultGCPLogin serves a Vault GCP auth login at the given path, recording the login parameters
+func newFakeVaultGCPLogin(t *testing.T, loginPath string, params map[string]interface{}) *vault.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/v1/"+loginPath {
+			http.Error(w, fmt.Sprintf("unexpected request %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"auth": {"client_token": "s.gcp-token"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	config := vaultapi.DefaultConfig()
+	config.Address = server.URL
+	rawClient, err := vaultapi.NewClient(config)
+	if err != nil {
+		t.Fatalf("error creating vault client: %v", err)
+	}
+	return &vault.Client{Client: rawClient, Logical: rawClient.Logical()}
+}
+
+func TestVaultGCPBackendGCELogin(t *testing.T) {
+	var identityQuery string
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		identityQuery = r.URL.RawQuery
+		fmt.Fprint(w, "gce-identity-token")
+	}))
+	defer metadataServer.Close()
+
+	os.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(metadataServer.URL, "http://"))
+	defer os.Unsetenv("GCE_METADATA_HOST")
+
+	params := make(map[string]interface{})
+	client := newFakeVaultGCPLogin(t, "auth/gcp-prod/login", params)
+	gcpCfg := &vault.GCPBackendConfig{LoginType: vault.GCPLoginTypeGCE, MountPath: "gcp-prod"}
+
+	token, err := vault.GCPBackendLogin(client, gcpCfg, &vault.Config{Role: "web"})
+	if err != nil {
+		t.Fatalf("error logging in with the gce login type: %v", err)
+	}
+	assert.Equal(t, token, "s.gcp-token")
+	assert.Equal(t, identityQuery, "audience=vault%2Fweb&format=full")
+	assert.Equal(t, params["role"], "web")
+	assert.Equal(t, params["jwt"], "gce-identity-token")
+}
+
+func TestVaultGCPBackendLoginPath(t *testing.T) {
+	for mountPath, wants := range map[string]string{
+		"":                "auth/gcp/login",
+		"gcp":             "auth/gcp/login",
+		"gcp-prod/":       "auth/gcp-prod/login",
+		"auth/gcp-prod":   "auth/gcp-prod/login",
+		"/teams/gcp-prod": "auth/teams/gcp-prod/login",
+	} {
+		cfg := &vault.GCPBackendConfig{MountPath: mountPath}
+		assert.Equal(t, cfg.LoginPath(), wants)
+	}
+
+	_, err := vault.GCPBackendLogin(nil, &vault.GCPBackendConfig{LoginType: "oidc"}, &vault.Config{Role: "web"})
+	if err == nil || !strings.Contains(err.Error(), "unknown GCP login type") {
+		t.Errorf("expected an unknown login type error, got: %v", err)
+	}
+}
+
+func TestGCPSignJWT(t *testing.T) {
+	var (
+		requestPath string
+		request     struct {
+			Delegates []string `json:"delegates"`
+			Payload   string   `json:"payload"`
+		}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestPath = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"keyId": "key-1", "signedJwt": "header.payload.signature"}`)
+	}))
+	defer server.Close()
+
+	payload := `{"aud":"vault/web","exp":1600000000,"sub":"vault-login@target-project.iam.gserviceaccount.com"}`
+	signedJWT, err := gcpSecretsManager.SignJWT(
+		context.Background(),
+		"vault-login@target-project.iam.gserviceaccount.com",
+		[]string{"delegate@middle-project.iam.gserviceaccount.com"},
+		payload,
+		option.WithEndpoint(server.URL),
+		option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "caller-token"})),
+	)
+	if err != nil {
+		t.Fatalf("error signing JWT: %v", err)
+	}
+
+	assert.Equal(t, signedJWT, "header.payload.signature")
+	assert.Equal(t, requestPath, "/v1/projects/-/serviceAccounts/vault-login@target-project.iam.gserviceaccount.com:signJwt")
+	assert.Equal(t, request.Delegates, []string{"projects/-/serviceAccounts/delegate@middle-project.iam.gserviceaccount.com"})
+	assert.Equal(t, request.Payload, payload)
+}